@@ -0,0 +1,115 @@
+package gocommons
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// AtomicFile is a File that is written to a temporary file next to its
+// destination. The destination only changes when Commit is called, so
+// readers either see the previous contents or all of the new contents.
+type AtomicFile struct {
+	*File
+	// Path the file is renamed to on Commit
+	Target  string
+	writers []Writer
+	done    bool
+}
+
+// OpenAtomic opens a temporary file in the same directory as path for
+// writing. Nothing is written to path until Commit is called.
+// If gz is GZ_UNKNOWN, the FileType is picked from the suffix of path.
+func OpenAtomic(path string, gz FileType) (*AtomicFile, error) {
+	if gz == GZ_UNKNOWN {
+		var ok bool
+		if gz, ok = FileTypeFromPath(path); !ok {
+			gz = GZ_FALSE
+		}
+	}
+
+	// Keep the permissions of the file we're replacing
+	perm := os.FileMode(0664)
+	if stat, err := os.Stat(path); err == nil {
+		perm = stat.Mode().Perm()
+	}
+
+	file, err := TempFile(filepath.Dir(path), "."+filepath.Base(path)+".", ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	if err = file.Chmod(perm); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	af := &AtomicFile{
+		File:   &File{Path: file.Name(), File: file, mode: os.O_WRONLY | os.O_CREATE | os.O_TRUNC, gz: gz, handle: file, fs: OS},
+		Target: path,
+	}
+	return af, nil
+}
+
+// Writer returns a Writer to the temporary file.
+// Writers are flushed and closed by Commit.
+func (af *AtomicFile) Writer(bufsize int) (Writer, error) {
+	writer, err := af.File.Writer(bufsize)
+	if err == nil {
+		af.writers = append(af.writers, writer)
+	}
+	return writer, err
+}
+
+// Commit finishes all writers, syncs the temporary file to disk and
+// renames it to Target. On failure, the temporary file is removed and
+// Target is left untouched.
+func (af *AtomicFile) Commit() (err error) {
+	if af.done {
+		return errors.New(fmt.Sprintf("'%v' has already been committed or aborted", af.Target))
+	}
+	defer func() {
+		if err != nil {
+			af.Abort()
+		}
+	}()
+
+	for idx := range af.writers {
+		// This also writes out the trailers of compressed streams
+		if err = af.writers[idx].Close(); err != nil {
+			return errors.New(fmt.Sprintf("Failed to close writer: %v", err))
+		}
+	}
+	if err = af.File.handle.Sync(); err != nil {
+		return errors.New(fmt.Sprintf("Failed to sync '%v': %v", af.Path, err))
+	}
+	if err = af.File.handle.Close(); err != nil {
+		return errors.New(fmt.Sprintf("Failed to close '%v': %v", af.Path, err))
+	}
+	if err = os.Rename(af.Path, af.Target); err != nil {
+		return errors.New(fmt.Sprintf("Failed to rename '%v' to '%v': %v", af.Path, af.Target, err))
+	}
+	af.done = true
+
+	// Make sure the rename itself survives a crash
+	if dir, err := os.Open(filepath.Dir(af.Target)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// Abort discards everything that was written. Target is left untouched.
+func (af *AtomicFile) Abort() error {
+	if af.done {
+		return nil
+	}
+	af.done = true
+	af.File.handle.Close()
+	return os.Remove(af.Path)
+}
+
+// Close aborts the file unless it has been committed
+func (af *AtomicFile) Close() {
+	af.Abort()
+}
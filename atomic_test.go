@@ -0,0 +1,109 @@
+package gocommons
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func atomicTempEntries(dir string, target string) []string {
+	matches, _ := filepath.Glob(filepath.Join(dir, "."+filepath.Base(target)+".*.tmp"))
+	return matches
+}
+
+func TestAtomicCommit(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var success bool
+
+	for _, target := range []string{"/tmp/atomic-commit.gz", "/tmp/atomic-commit.txt"} {
+		os.Remove(target)
+
+		af, err := OpenAtomic(target, GZ_UNKNOWN)
+		require.Nil(err, "Failed to open atomic file", err)
+		defer af.Close()
+
+		writer, err := af.Writer(0)
+		require.Nil(err, "Failed to get writer", err)
+		writer.Write([]byte("Hello World"))
+
+		// Nothing should be visible until we commit
+		exists, _ := Exists(target)
+		assert.False(exists, "Target exists before commit")
+		assert.Equal(1, len(atomicTempEntries("/tmp", target)), "Expected a temp file")
+
+		assert.Nil(af.Commit(), "Failed to commit")
+		assert.NotNil(af.Commit(), "Should have failed to commit twice")
+		assert.Equal(0, len(atomicTempEntries("/tmp", target)), "Temp file was left behind")
+
+		f, err := Open(target, os.O_RDONLY, GZ_UNKNOWN)
+		require.Nil(err, "Failed to open committed file", err)
+		if success, err = CheckFileContentsMatch(f, "Hello World", true); err != nil || !success {
+			assert.Fail(fmt.Sprintf("Failed to verify file contents(%v): %v", target, err))
+		}
+		f.Close()
+		os.Remove(target)
+	}
+}
+
+func TestAtomicAbort(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var success bool
+	target := "/tmp/atomic-abort.gz"
+
+	// Create the original
+	af, err := OpenAtomic(target, GZ_TRUE)
+	require.Nil(err)
+	writer, _ := af.Writer(4096)
+	writer.Write([]byte("original"))
+	require.Nil(af.Commit())
+	defer os.Remove(target)
+
+	check := func() {
+		f, err := Open(target, os.O_RDONLY, GZ_UNKNOWN)
+		require.Nil(err)
+		defer f.Close()
+		if success, err = CheckFileContentsMatch(f, "original", true); err != nil || !success {
+			assert.Fail(fmt.Sprintf("Original file was modified: %v", err))
+		}
+		assert.Equal(0, len(atomicTempEntries("/tmp", target)), "Temp file was left behind")
+	}
+
+	// Explicit abort
+	af, err = OpenAtomic(target, GZ_TRUE)
+	require.Nil(err)
+	writer, _ = af.Writer(0)
+	writer.Write([]byte("replacement"))
+	assert.Nil(af.Abort())
+	assert.NotNil(af.Commit(), "Should not be able to commit after abort")
+	check()
+
+	// Close without commit
+	af, err = OpenAtomic(target, GZ_TRUE)
+	require.Nil(err)
+	writer, _ = af.Writer(0)
+	writer.Write([]byte("replacement"))
+	writer.Flush()
+	af.Close()
+	check()
+}
+
+func TestAtomicBadDir(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	_, err := OpenAtomic("/_not_exists_/atomic.gz", GZ_TRUE)
+	assert.NotNil(err, "Should have failed to open in a directory that does not exist")
+}
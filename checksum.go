@@ -0,0 +1,373 @@
+package gocommons
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const SHA256_SUFFIX = ".sha256"
+
+// Checksum is the CRC32 (IEEE) and SHA-256 of the bytes written to it
+type Checksum struct {
+	crc   hash.Hash32
+	sha   hash.Hash
+	Bytes int64
+}
+
+func NewChecksum() *Checksum {
+	return &Checksum{crc: crc32.NewIEEE(), sha: sha256.New()}
+}
+
+func (c *Checksum) Write(b []byte) (int, error) {
+	c.crc.Write(b)
+	c.sha.Write(b)
+	c.Bytes += int64(len(b))
+	return len(b), nil
+}
+
+func (c *Checksum) CRC32() uint32 {
+	return c.crc.Sum32()
+}
+
+// SHA256 returns the hex encoded SHA-256 as printed by sha256sum
+func (c *Checksum) SHA256() string {
+	return hex.EncodeToString(c.sha.Sum(nil))
+}
+
+// Checksums of the contents of a File.
+// They are complete once the Writer has been closed or the reader has
+// returned io.EOF.
+type Checksums struct {
+	// Of the uncompressed data
+	Uncompressed *Checksum
+	// Of the bytes in the file. This is nil unless it was asked for.
+	Compressed *Checksum
+	gz         FileType
+}
+
+// File returns the checksum of the bytes as they are stored in the file.
+// This is what sha256sum sees.
+func (c *Checksums) File() (*Checksum, error) {
+	if c.Compressed != nil {
+		return c.Compressed, nil
+	}
+	if c.gz == GZ_FALSE {
+		return c.Uncompressed, nil
+	}
+	return nil, errors.New("Checksum of compressed bytes was not computed")
+}
+
+func newChecksums(gz FileType, compressed bool) *Checksums {
+	sums := &Checksums{Uncompressed: NewChecksum(), gz: gz}
+	if compressed {
+		sums.Compressed = NewChecksum()
+	}
+	return sums
+}
+
+// checksumStream hashes everything written to the compressor
+type checksumStream struct {
+	IWriter
+	sum *Checksum
+}
+
+func (cs *checksumStream) Write(b []byte) (int, error) {
+	n, err := cs.IWriter.Write(b)
+	cs.sum.Write(b[:n])
+	return n, err
+}
+
+func (cs *checksumStream) Close() error {
+	if closer, ok := cs.IWriter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return cs.IWriter.Flush()
+}
+
+// ChecksumWriter is like Writer but also checksums everything written.
+// If compressed is set, the bytes that end up in the file are hashed too.
+func (f *File) ChecksumWriter(bufsize int, compressed bool) (Writer, *Checksums, error) {
+	sums := newChecksums(f.gz, compressed)
+	var dst io.Writer = f.handle
+	if compressed {
+		dst = io.MultiWriter(f.handle, sums.Compressed)
+	}
+	stream, err := f.streamTo(dst)
+	if err != nil {
+		return Writer{}, nil, err
+	}
+	return newWriter(&checksumStream{stream, sums.Uncompressed}, f.gz, bufsize), sums, nil
+}
+
+type checksumReader struct {
+	io.Reader
+	src  io.Reader
+	sums *Checksums
+}
+
+func (cr *checksumReader) Read(b []byte) (int, error) {
+	n, err := cr.Reader.Read(b)
+	cr.sums.Uncompressed.Write(b[:n])
+	if err == io.EOF && cr.sums.Compressed != nil {
+		// The decompressor may not have looked at trailing bytes
+		if _, cerr := io.Copy(io.Discard, cr.src); cerr != nil {
+			err = cerr
+		}
+	}
+	return n, err
+}
+
+// ChecksumReader is like RawReader but also checksums everything read.
+// If compressed is set, the bytes read from the file are hashed too.
+func (f *File) ChecksumReader(compressed bool) (io.Reader, *Checksums, error) {
+	sums := newChecksums(f.gz, compressed)
+	var src io.Reader = f.handle
+	if compressed {
+		src = io.TeeReader(f.handle, sums.Compressed)
+	}
+	raw, err := f.rawReaderFrom(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &checksumReader{raw, src, sums}, sums, nil
+}
+
+// SHA256SidecarPath returns the path of the .sha256 file for path
+func SHA256SidecarPath(path string) string {
+	return path + SHA256_SUFFIX
+}
+
+// WriteSHA256Sidecar writes the checksum of the file at path to its
+// .sha256 sidecar so that `sha256sum -c` can check it.
+func WriteSHA256Sidecar(path string, sums *Checksums) error {
+	sum, err := sums.File()
+	if err != nil {
+		return err
+	}
+	return writeManifest(SHA256SidecarPath(path), []string{sum.SHA256()}, []string{filepath.Base(path)})
+}
+
+func writeManifest(path string, sums []string, names []string) (err error) {
+	var out *AtomicFile
+	var writer Writer
+
+	if out, err = OpenAtomic(path, GZ_FALSE); err != nil {
+		return
+	}
+	defer out.Close()
+	if writer, err = out.Writer(0); err != nil {
+		return
+	}
+	for idx := range names {
+		// Like sha256sum, lines with awkward names start with a backslash
+		prefix, name := "", names[idx]
+		if escaped := manifestEscaper.Replace(name); escaped != name {
+			prefix, name = "\\", escaped
+		}
+		if _, err = fmt.Fprintf(writer, "%v%v  %v\n", prefix, sums[idx], name); err != nil {
+			return
+		}
+	}
+	return out.Commit()
+}
+
+var manifestEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r")
+
+// unescapeManifestName reverses manifestEscaper
+func unescapeManifestName(name string) (string, bool) {
+	var sb strings.Builder
+	for idx := 0; idx < len(name); idx++ {
+		if name[idx] != '\\' {
+			sb.WriteByte(name[idx])
+			continue
+		}
+		if idx++; idx == len(name) {
+			return "", false
+		}
+		switch name[idx] {
+		case '\\':
+			sb.WriteByte('\\')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		default:
+			return "", false
+		}
+	}
+	return sb.String(), true
+}
+
+// SHA256File returns the hex encoded SHA-256 of the bytes in the file at path
+func SHA256File(path string) (string, error) {
+	f, err := Open(path, os.O_RDONLY, GZ_FALSE)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sum := NewChecksum()
+	if _, err = io.Copy(sum, f.handle); err != nil {
+		return "", errors.New(fmt.Sprintf("Failed to read '%v': %v", path, err))
+	}
+	return sum.SHA256(), nil
+}
+
+// WriteManifest writes a sha256sum compatible manifest of the files under
+// root that match patterns. Names are relative to root.
+func WriteManifest(manifest string, root string, patterns []string) error {
+	files, err := ListFiles(root, patterns)
+	if err != nil {
+		return err
+	}
+	sums := make([]string, len(files))
+	names := make([]string, len(files))
+	for idx, file := range files {
+		if sums[idx], err = SHA256File(file); err != nil {
+			return err
+		}
+		if names[idx], err = filepath.Rel(root, file); err != nil {
+			return err
+		}
+		names[idx] = filepath.ToSlash(names[idx])
+	}
+	return writeManifest(manifest, sums, names)
+}
+
+// ReadManifest parses a manifest as written by sha256sum.
+// It maps names to hex encoded checksums.
+func ReadManifest(manifest string) (map[string]string, error) {
+	f, err := Open(manifest, os.O_RDONLY, GZ_UNKNOWN)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner, err := f.Reader(0)
+	if err != nil {
+		return nil, err
+	}
+	sums := make(map[string]string)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		escaped := strings.HasPrefix(line, "\\")
+		if escaped {
+			line = line[1:]
+		}
+		// "<sum>  <name>" in text mode or "<sum> *<name>" in binary mode
+		if len(line) < 66 || line[64] != ' ' || (line[65] != ' ' && line[65] != '*') {
+			return nil, errors.New(fmt.Sprintf("%v:%d: Malformed line", manifest, lineno))
+		}
+		sum := strings.ToLower(line[:64])
+		if _, err := hex.DecodeString(sum); err != nil {
+			return nil, errors.New(fmt.Sprintf("%v:%d: Malformed checksum", manifest, lineno))
+		}
+		name := line[66:]
+		if escaped {
+			var ok bool
+			if name, ok = unescapeManifestName(name); !ok {
+				return nil, errors.New(fmt.Sprintf("%v:%d: Malformed name", manifest, lineno))
+			}
+		}
+		sums[name] = sum
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
+
+// VerifyResult lists the outcome of VerifyManifest by file name
+type VerifyResult struct {
+	OK []string
+	// The checksum did not match
+	Failed []string
+	// In the manifest but not found under root
+	Missing []string
+	// Found under root but not in the manifest
+	Unlisted []string
+}
+
+func (vr *VerifyResult) Passed() bool {
+	return len(vr.Failed) == 0 && len(vr.Missing) == 0
+}
+
+// VerifyManifest checks the files under root that match patterns against
+// a sha256sum compatible manifest whose names are relative to root.
+func VerifyManifest(manifest string, root string, patterns []string) (*VerifyResult, error) {
+	expected, err := ReadManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ListFiles(root, patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &VerifyResult{}
+	seen := make(map[string]bool)
+	for _, file := range files {
+		name, err := filepath.Rel(root, file)
+		if err != nil {
+			return nil, err
+		}
+		name = filepath.ToSlash(name)
+		sum, ok := expected[name]
+		if !ok {
+			result.Unlisted = append(result.Unlisted, name)
+			continue
+		}
+		seen[name] = true
+		actual, err := SHA256File(file)
+		if err != nil {
+			return nil, err
+		}
+		if actual == sum {
+			result.OK = append(result.OK, name)
+		} else {
+			result.Failed = append(result.Failed, name)
+		}
+	}
+	// Like sha256sum -c, everything in the manifest is checked. Even files
+	// that patterns would not have found
+	var others []string
+	for name := range expected {
+		if !seen[name] {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	for _, name := range others {
+		// Don't let the manifest point outside of root
+		clean := path.Clean(name)
+		if path.IsAbs(clean) || filepath.IsAbs(filepath.FromSlash(clean)) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return nil, errors.New(fmt.Sprintf("'%v' in '%v' is not under root", name, manifest))
+		}
+		actual, err := SHA256File(filepath.Join(root, filepath.FromSlash(name)))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			result.Missing = append(result.Missing, name)
+		case err != nil:
+			return nil, err
+		case actual == expected[name]:
+			result.OK = append(result.OK, name)
+		default:
+			result.Failed = append(result.Failed, name)
+		}
+	}
+	return result, nil
+}
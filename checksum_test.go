@@ -0,0 +1,225 @@
+package gocommons
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestChecksumWriterReader(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data := gzipTestData(5000)
+	for _, gz := range []FileType{GZ_FALSE, GZ_TRUE, ZSTD} {
+		for _, bufsize := range []int{0, 4096} {
+			path := "/tmp/checksum-test" + gz.Suffix()
+			f, err := Open(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, gz)
+			require.Nil(err)
+			writer, sums, err := f.ChecksumWriter(bufsize, true)
+			require.Nil(err)
+			writer.Write(data)
+			require.Nil(writer.Close())
+			f.Close()
+
+			onDisk, err := os.ReadFile(path)
+			require.Nil(err)
+			assert.Equal(int64(len(data)), sums.Uncompressed.Bytes)
+			assert.Equal(sha256Hex(data), sums.Uncompressed.SHA256())
+			assert.Equal(crc32.ChecksumIEEE(data), sums.Uncompressed.CRC32())
+			assert.Equal(sha256Hex(onDisk), sums.Compressed.SHA256(), "Compressed checksum of %v", path)
+			assert.Equal(int64(len(onDisk)), sums.Compressed.Bytes)
+
+			f, err = Open(path, os.O_RDONLY, GZ_UNKNOWN)
+			require.Nil(err)
+			reader, rsums, err := f.ChecksumReader(true)
+			require.Nil(err)
+			got, err := io.ReadAll(reader)
+			assert.Nil(err)
+			assert.Equal(len(data), len(got))
+			assert.Equal(sums.Uncompressed.SHA256(), rsums.Uncompressed.SHA256())
+			assert.Equal(sums.Compressed.SHA256(), rsums.Compressed.SHA256(), "Compressed bytes read from %v", path)
+			f.Close()
+			os.Remove(path)
+		}
+	}
+}
+
+func TestSHA256Sidecar(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := "/tmp/sha256-sidecar-test"
+	os.RemoveAll(dir)
+	require.Nil(Makedirs(dir))
+	defer os.RemoveAll(dir)
+
+	for _, gz := range []FileType{GZ_FALSE, GZ_TRUE} {
+		path := filepath.Join(dir, "out"+gz.Suffix())
+		f, err := Open(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, gz)
+		require.Nil(err)
+		writer, sums, err := f.ChecksumWriter(0, false)
+		require.Nil(err)
+		writer.Write([]byte("Hello World\n"))
+		require.Nil(writer.Close())
+		f.Close()
+
+		err = WriteSHA256Sidecar(path, sums)
+		if gz == GZ_TRUE {
+			assert.NotNil(err, "Compressed bytes were not hashed")
+			continue
+		}
+		require.Nil(err)
+		sidecar, err := os.ReadFile(SHA256SidecarPath(path))
+		require.Nil(err)
+		assert.Equal(sha256Hex([]byte("Hello World\n"))+"  out\n", string(sidecar))
+
+		if _, err := exec.LookPath("sha256sum"); err == nil {
+			cmd := exec.Command("sha256sum", "-c", filepath.Base(SHA256SidecarPath(path)))
+			cmd.Dir = dir
+			out, err := cmd.CombinedOutput()
+			assert.Nil(err, string(out))
+		}
+	}
+}
+
+func TestVerifyManifest(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := "/tmp/verify-manifest-test"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "tree")
+	require.Nil(Makedirs(filepath.Join(root, "sub")))
+	for _, name := range []string{"a.txt", "b.txt", "sub/c.txt", "sub/d.log"} {
+		require.Nil(os.WriteFile(filepath.Join(root, name), []byte(name+"\n"), 0664))
+	}
+
+	manifest := filepath.Join(dir, "SHA256SUMS")
+	require.Nil(WriteManifest(manifest, root, []string{"*.txt", "*.log"}))
+
+	if _, err := exec.LookPath("sha256sum"); err == nil {
+		cmd := exec.Command("sha256sum", "-c", manifest)
+		cmd.Dir = root
+		out, err := cmd.CombinedOutput()
+		assert.Nil(err, string(out))
+	}
+
+	result, err := VerifyManifest(manifest, root, []string{"*.txt"})
+	require.Nil(err)
+	assert.True(result.Passed())
+	assert.Equal([]string{"a.txt", "b.txt", "sub/c.txt", "sub/d.log"}, result.OK)
+
+	require.Nil(os.WriteFile(filepath.Join(root, "a.txt"), []byte("changed\n"), 0664))
+	require.Nil(os.Remove(filepath.Join(root, "sub/d.log")))
+	require.Nil(os.WriteFile(filepath.Join(root, "new.txt"), []byte("new\n"), 0664))
+	result, err = VerifyManifest(manifest, root, []string{"*.txt"})
+	require.Nil(err)
+	assert.False(result.Passed())
+	assert.Equal([]string{"b.txt", "sub/c.txt"}, result.OK)
+	assert.Equal([]string{"a.txt"}, result.Failed)
+	assert.Equal([]string{"sub/d.log"}, result.Missing)
+	assert.Equal([]string{"new.txt"}, result.Unlisted)
+
+	bad := filepath.Join(dir, "bad")
+	require.Nil(os.WriteFile(bad, []byte("not a manifest\n"), 0664))
+	_, err = VerifyManifest(bad, root, []string{"*"})
+	assert.NotNil(err)
+
+	// Binary mode lines are accepted too
+	require.Nil(os.WriteFile(bad, []byte(sha256Hex([]byte("b.txt\n"))+" *b.txt\n"), 0664))
+	result, err = VerifyManifest(bad, root, []string{"b.txt"})
+	require.Nil(err)
+	assert.Equal([]string{"b.txt"}, result.OK)
+}
+
+func TestManifestEscapedNames(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := "/tmp/manifest-escaped-test"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "tree")
+	require.Nil(Makedirs(root))
+	names := []string{"back\\slash.txt", "new\nline.txt", "plain.txt"}
+	for _, name := range names {
+		require.Nil(os.WriteFile(filepath.Join(root, name), []byte(name), 0664))
+	}
+
+	manifest := filepath.Join(dir, "SHA256SUMS")
+	require.Nil(WriteManifest(manifest, root, []string{"*"}))
+	data, err := os.ReadFile(manifest)
+	require.Nil(err)
+	assert.Contains(string(data), "\\"+sha256Hex([]byte(names[1]))+"  new\\nline.txt\n")
+
+	if _, err := exec.LookPath("sha256sum"); err == nil {
+		cmd := exec.Command("sha256sum", "-c", manifest)
+		cmd.Dir = root
+		out, err := cmd.CombinedOutput()
+		assert.Nil(err, string(out))
+	}
+
+	sums, err := ReadManifest(manifest)
+	require.Nil(err)
+	for _, name := range names {
+		assert.Equal(sha256Hex([]byte(name)), sums[name], "Wrong sum for %q", name)
+	}
+	result, err := VerifyManifest(manifest, root, []string{"*"})
+	require.Nil(err)
+	assert.True(result.Passed())
+	assert.Equal(names, result.OK)
+
+	require.Nil(os.WriteFile(manifest, []byte("\\"+sha256Hex(nil)+"  bad\\escape\n"), 0664))
+	_, err = ReadManifest(manifest)
+	assert.NotNil(err, "Should have failed on bad escape")
+}
+
+func TestVerifyManifestOutsideRoot(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := "/tmp/manifest-outside-test"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "tree")
+	require.Nil(Makedirs(root))
+	require.Nil(os.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0664))
+
+	manifest := filepath.Join(dir, "SHA256SUMS")
+	for _, name := range []string{"../secret", "sub/../../secret", "/etc/passwd", ".."} {
+		require.Nil(os.WriteFile(manifest, []byte(sha256Hex([]byte("secret"))+"  "+name+"\n"), 0664))
+		_, err := VerifyManifest(manifest, root, []string{"*"})
+		assert.NotNil(err, "Should have rejected %v", name)
+	}
+	// Names that merely start with dots are fine
+	require.Nil(os.WriteFile(filepath.Join(root, "..data"), []byte("d"), 0664))
+	require.Nil(os.WriteFile(manifest, []byte(sha256Hex([]byte("d"))+"  ./..data\n"), 0664))
+	result, err := VerifyManifest(manifest, root, []string{"none"})
+	require.Nil(err)
+	assert.Equal([]string{"./..data"}, result.OK)
+}
@@ -0,0 +1,309 @@
+package gocommons
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// Codec describes a file format that Open knows how to read and,
+// optionally, write. Codecs are looked up by FileType.
+type Codec struct {
+	Type FileType
+	Name string
+	// Extensions are used to identify files that cannot be sniffed,
+	// such as files that are empty or opened write-only.
+	// The first extension is the one returned by FileType.Suffix.
+	Extensions []string
+	// Magic holds the byte sequences that a file of this format
+	// starts with
+	Magic     [][]byte
+	NewReader func(r io.Reader) (io.Reader, error)
+	// NewWriter may be nil for formats that can only be read
+	NewWriter func(w io.Writer) (IWriter, error)
+}
+
+var (
+	codecs   = make(map[FileType]*Codec)
+	codecsmu sync.RWMutex
+	// Length of the longest registered magic
+	maxMagicLen int
+)
+
+func init() {
+	builtin := []*Codec{
+		{
+			Type: GZ_FALSE,
+			Name: "plain",
+			NewReader: func(r io.Reader) (io.Reader, error) {
+				return bufio.NewReader(r), nil
+			},
+			NewWriter: func(w io.Writer) (IWriter, error) {
+				return bufio.NewWriter(w), nil
+			},
+		},
+		{
+			Type:       GZ_TRUE,
+			Name:       "gzip",
+			Extensions: []string{".gz"},
+			Magic:      [][]byte{{0x1f, 0x8b}},
+			NewReader: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
+			NewWriter: func(w io.Writer) (IWriter, error) {
+				return gzip.NewWriter(w), nil
+			},
+		},
+		{
+			Type:       ZSTD,
+			Name:       "zstd",
+			Extensions: []string{".zst"},
+			Magic:      [][]byte{{0x28, 0xb5, 0x2f, 0xfd}},
+			NewReader: func(r io.Reader) (io.Reader, error) {
+				// A single-threaded decoder does not hold on to any goroutines
+				// and so does not need to be closed
+				return zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			},
+			NewWriter: func(w io.Writer) (IWriter, error) {
+				return zstd.NewWriter(w)
+			},
+		},
+		{
+			Type:       XZ,
+			Name:       "xz",
+			Extensions: []string{".xz"},
+			Magic:      [][]byte{{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+			NewReader: func(r io.Reader) (io.Reader, error) {
+				return xz.NewReader(bufio.NewReader(r))
+			},
+		},
+		{
+			Type:       BZIP2,
+			Name:       "bzip2",
+			Extensions: []string{".bz2"},
+			Magic:      bzip2Magic(),
+			NewReader: func(r io.Reader) (io.Reader, error) {
+				return bzip2.NewReader(bufio.NewReader(r)), nil
+			},
+		},
+		{
+			Type:       LZ4,
+			Name:       "lz4",
+			Extensions: []string{".lz4"},
+			Magic:      [][]byte{{0x04, 0x22, 0x4d, 0x18}},
+			NewReader: func(r io.Reader) (io.Reader, error) {
+				return lz4.NewReader(r), nil
+			},
+			NewWriter: func(w io.Writer) (IWriter, error) {
+				// Favour speed over ratio. This is meant for intermediate files.
+				writer := lz4.NewWriter(w)
+				if err := writer.Apply(lz4.CompressionLevelOption(lz4.Fast)); err != nil {
+					return nil, err
+				}
+				return writer, nil
+			},
+		},
+	}
+	for _, codec := range builtin {
+		if err := RegisterCodec(codec); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// bzip2Magic returns the stream header followed by the magic of the
+// first block, or of the end of the stream if it is empty. "BZh" alone
+// is too common at the start of text files.
+func bzip2Magic() (magic [][]byte) {
+	block := []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	eos := []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+	for level := byte('1'); level <= '9'; level++ {
+		header := []byte{'B', 'Z', 'h', level}
+		magic = append(magic, append(append([]byte(nil), header...), block...))
+		magic = append(magic, append(append([]byte(nil), header...), eos...))
+	}
+	return
+}
+
+// RegisterCodec makes a codec available to Open, File.RawReader and
+// File.Writer. It is an error to register a FileType twice.
+func RegisterCodec(codec *Codec) error {
+	if codec == nil || codec.NewReader == nil {
+		return errors.New("Codec must have a reader")
+	}
+	if codec.Type == GZ_UNKNOWN {
+		return errors.New("Cannot register a codec for GZ_UNKNOWN")
+	}
+
+	codecsmu.Lock()
+	defer codecsmu.Unlock()
+	if existing, ok := codecs[codec.Type]; ok {
+		return errors.New(fmt.Sprintf("FileType %d is already registered to '%v'", codec.Type, existing.Name))
+	}
+	codecs[codec.Type] = codec
+	for _, magic := range codec.Magic {
+		if len(magic) > maxMagicLen {
+			maxMagicLen = len(magic)
+		}
+	}
+	return nil
+}
+
+// GetCodec returns the codec registered for the FileType
+func GetCodec(ft FileType) (codec *Codec, ok bool) {
+	codecsmu.RLock()
+	defer codecsmu.RUnlock()
+	codec, ok = codecs[ft]
+	return
+}
+
+// sortedCodecs returns the registered codecs ordered by FileType so
+// that detection does not depend on map iteration order
+func sortedCodecs() []*Codec {
+	codecsmu.RLock()
+	defer codecsmu.RUnlock()
+	ret := make([]*Codec, 0, len(codecs))
+	for _, codec := range codecs {
+		ret = append(ret, codec)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Type < ret[j].Type })
+	return ret
+}
+
+// Suffix returns the file suffix conventionally used for the FileType
+func (ft FileType) Suffix() string {
+	if codec, ok := GetCodec(ft); ok && len(codec.Extensions) > 0 {
+		return codec.Extensions[0]
+	}
+	return ""
+}
+
+// Writable returns whether File.Writer supports the FileType.
+// Some formats, such as xz and bzip2, can only be read.
+func (ft FileType) Writable() bool {
+	codec, ok := GetCodec(ft)
+	return ok && codec.NewWriter != nil
+}
+
+// FileTypeFromPath identifies the FileType from the extension of path.
+// When multiple extensions match, the longest one wins.
+func FileTypeFromPath(path string) (ft FileType, ok bool) {
+	longest := 0
+	for _, codec := range sortedCodecs() {
+		for _, ext := range codec.Extensions {
+			if len(ext) > longest && strings.HasSuffix(path, ext) {
+				longest = len(ext)
+				ft = codec.Type
+				ok = true
+			}
+		}
+	}
+	if !ok {
+		ft = GZ_UNKNOWN
+	}
+	return
+}
+
+// SniffFileType identifies the FileType from the first few bytes of a
+// file. When multiple magics match, the longest one wins.
+// Data that does not match any magic is considered to be plain (GZ_FALSE).
+func SniffFileType(header []byte) FileType {
+	ft := GZ_FALSE
+	longest := 0
+	for _, codec := range sortedCodecs() {
+		for _, magic := range codec.Magic {
+			if len(magic) > longest && bytes.HasPrefix(header, magic) {
+				longest = len(magic)
+				ft = codec.Type
+			}
+		}
+	}
+	return ft
+}
+
+// sniffReader identifies the FileType of the data in r.
+// name is used as a fallback when there is too little data to sniff.
+// The returned reader yields all of the data in r.
+func sniffReader(r io.Reader, name string) (io.Reader, FileType, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(sniffLen())
+	if err != nil && err != io.EOF {
+		return nil, GZ_UNKNOWN, err
+	}
+	if len(header) == 0 {
+		if ft, ok := FileTypeFromPath(name); ok {
+			return buffered, ft, nil
+		}
+	}
+	return buffered, SniffFileType(header), nil
+}
+
+// codecReader reads the possibly compressed contents of an archive entry
+type codecReader struct {
+	name   string
+	gz     FileType
+	reader io.Reader
+}
+
+// newCodecReader sniffs the FileType of the data in r. name is used as
+// in sniffReader.
+func newCodecReader(r io.Reader, name string) (codecReader, error) {
+	reader, gz, err := sniffReader(r, name)
+	return codecReader{name, gz, reader}, err
+}
+
+// FileType returns the FileType of the contents
+func (c *codecReader) FileType() FileType {
+	return c.gz
+}
+
+// RawReader returns a reader of the uncompressed contents
+func (c *codecReader) RawReader() (io.Reader, error) {
+	codec, ok := GetCodec(c.gz)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("No codec registered for FileType %d", c.gz))
+	}
+	return codec.NewReader(c.reader)
+}
+
+func (c *codecReader) Reader(bufsize int) (*bufio.Scanner, error) {
+	reader, err := c.RawReader()
+	if err != nil {
+		return nil, err
+	}
+	return newScanner(reader, bufsize), err
+}
+
+func (c *codecReader) AsyncReadWithBufsize(splitFunction bufio.SplitFunc, bufsize int, channel chan string) {
+	reader, err := c.Reader(bufsize)
+	asyncScan(reader, err, splitFunction, channel, nil)
+}
+
+// AsyncReadContext is like File.AsyncReadContext
+func (c *codecReader) AsyncReadContext(ctx context.Context, splitFunction bufio.SplitFunc, bufsize int, channel chan<- string) error {
+	reader, err := c.Reader(bufsize)
+	return scanContext(ctx, c.name, reader, err, splitFunction, channel, nil)
+}
+
+func (c *codecReader) AsyncRead(splitFunction bufio.SplitFunc, channel chan string) {
+	c.AsyncReadWithBufsize(splitFunction, 1048576, channel)
+}
+
+// sniffLen returns the number of bytes needed by SniffFileType
+func sniffLen() int {
+	codecsmu.RLock()
+	defer codecsmu.RUnlock()
+	return maxMagicLen
+}
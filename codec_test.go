@@ -0,0 +1,183 @@
+package gocommons
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSniffFileType(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	expected := map[string]FileType{
+		"test_files/open-test.txt":     GZ_FALSE,
+		"test_files/open-test.gz":      GZ_TRUE,
+		"test_files/open-test.fake.gz": GZ_FALSE,
+		"test_files/open-test.zst":     ZSTD,
+		"test_files/open-test.xz":      XZ,
+		"test_files/open-test.bz2":     BZIP2,
+		"test_files/open-test.lz4":     LZ4,
+	}
+	for path, ft := range expected {
+		f, err := Open(path, os.O_RDONLY, GZ_UNKNOWN)
+		assert.Nil(err, "Failed to open valid file", err)
+		assert.Equal(ft, f.gz, fmt.Sprintf("Wrong FileType for %v", path))
+		f.Close()
+	}
+
+	assert.Equal(GZ_FALSE, SniffFileType(nil), "Empty header should be plain")
+	assert.Equal(GZ_FALSE, SniffFileType([]byte{0x1f}), "Partial magic should be plain")
+	assert.Equal(GZ_FALSE, SniffFileType([]byte("BZh is not bzip2\n")), "Text should be plain")
+	assert.Equal(GZ_FALSE, SniffFileType([]byte("BZh9 is not bzip2")), "Text should be plain")
+	assert.Equal(BZIP2, SniffFileType([]byte("BZh91AY&SY\x00")), "Should have sniffed bzip2 block")
+	assert.Equal(BZIP2, SniffFileType([]byte("BZh9\x17\x72\x45\x38\x50\x90")), "Should have sniffed empty bzip2")
+}
+
+func TestSniffMislabeledGz(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	var success bool
+	var err error
+	var f *File
+
+	// Write a gzip file with a plain suffix
+	f, err = Open("/tmp/mislabeled-gz.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, GZ_TRUE)
+	assert.Nil(err, "Failed to open valid file", err)
+	defer os.Remove(f.Path)
+	writer, err := f.Writer(0)
+	assert.Nil(err, "Failed to get writer", err)
+	writer.Write([]byte("Hello World"))
+	writer.Close()
+	f.Close()
+
+	f, err = Open("/tmp/mislabeled-gz.txt", os.O_RDONLY, GZ_UNKNOWN)
+	assert.Nil(err, "Failed to open valid file", err)
+	defer f.Close()
+	assert.Equal(GZ_TRUE, f.gz, "Should have sniffed gzip file")
+	if success, err = CheckFileContentsMatch(f, "Hello World", true); err != nil || !success {
+		assert.Fail(fmt.Sprintf("Failed to verify file contents: %v", err))
+	}
+}
+
+func TestFileTypeFromPath(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	expected := map[string]FileType{
+		"a.gz":      GZ_TRUE,
+		"a.txt.zst": ZSTD,
+		"a.xz":      XZ,
+		"a.bz2":     BZIP2,
+		"a.lz4":     LZ4,
+	}
+	for path, ft := range expected {
+		got, ok := FileTypeFromPath(path)
+		assert.True(ok, fmt.Sprintf("Failed to identify %v", path))
+		assert.Equal(ft, got, fmt.Sprintf("Wrong FileType for %v", path))
+	}
+
+	got, ok := FileTypeFromPath("a.txt")
+	assert.False(ok, "Should not have identified a.txt")
+	assert.Equal(GZ_UNKNOWN, got)
+}
+
+// A trivial codec that prefixes the data with a header
+var testCodecMagic = []byte("GOCOMMONS-TEST\n")
+
+const testCodecType FileType = 100
+
+type testCodecWriter struct {
+	*bufio.Writer
+	wroteMagic bool
+}
+
+func (w *testCodecWriter) Write(b []byte) (int, error) {
+	if !w.wroteMagic {
+		w.wroteMagic = true
+		if _, err := w.Writer.Write(testCodecMagic); err != nil {
+			return 0, err
+		}
+	}
+	return w.Writer.Write(b)
+}
+
+func init() {
+	err := RegisterCodec(&Codec{
+		Type:       testCodecType,
+		Name:       "test",
+		Extensions: []string{".gctest"},
+		Magic:      [][]byte{testCodecMagic},
+		NewReader: func(r io.Reader) (io.Reader, error) {
+			header := make([]byte, len(testCodecMagic))
+			if _, err := io.ReadFull(r, header); err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(header, testCodecMagic) {
+				return nil, errors.New(fmt.Sprintf("Bad header: %v", header))
+			}
+			return r, nil
+		},
+		NewWriter: func(w io.Writer) (IWriter, error) {
+			return &testCodecWriter{bufio.NewWriter(w), false}, nil
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	var success bool
+	var err error
+	var f *File
+
+	// Duplicates and invalid codecs should fail
+	err = RegisterCodec(&Codec{Type: GZ_TRUE, NewReader: func(r io.Reader) (io.Reader, error) { return r, nil }})
+	assert.NotNil(err, "Should have failed to register duplicate codec")
+	err = RegisterCodec(&Codec{Type: testCodecType + 1})
+	assert.NotNil(err, "Should have failed to register codec without reader")
+	err = RegisterCodec(&Codec{Type: GZ_UNKNOWN, NewReader: func(r io.Reader) (io.Reader, error) { return r, nil }})
+	assert.NotNil(err, "Should have failed to register GZ_UNKNOWN")
+
+	assert.Equal(".gctest", testCodecType.Suffix())
+	assert.True(testCodecType.Writable())
+
+	// The suffix is used for new files
+	f, err = Open("/tmp/register-codec.gctest", os.O_RDWR|os.O_CREATE|os.O_TRUNC, GZ_UNKNOWN)
+	assert.Nil(err, "Failed to open valid file", err)
+	defer os.Remove(f.Path)
+	assert.Equal(testCodecType, f.gz)
+
+	writer, err := f.Writer(0)
+	assert.Nil(err, "Failed to get writer", err)
+	writer.Write([]byte("Hello World"))
+	writer.Flush()
+	writer.Close()
+	f.Close()
+
+	// ..and the magic for existing ones
+	os.Rename("/tmp/register-codec.gctest", "/tmp/register-codec.txt")
+	f, err = Open("/tmp/register-codec.txt", os.O_RDONLY, GZ_UNKNOWN)
+	assert.Nil(err, "Failed to open valid file", err)
+	defer os.Remove(f.Path)
+	defer f.Close()
+	assert.Equal(testCodecType, f.gz)
+	if success, err = CheckFileContentsMatch(f, "Hello World", true); err != nil || !success {
+		assert.Fail(fmt.Sprintf("Failed to verify file contents: %v", err))
+	}
+}
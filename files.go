@@ -2,16 +2,17 @@ package gocommons
 
 import (
 	"bufio"
-	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"sort"
-	"strings"
+	"runtime"
+	"time"
 
-	"github.com/bmatcuk/doublestar"
+	"github.com/klauspost/pgzip"
 )
 
 type FileType int
@@ -20,17 +21,30 @@ const (
 	GZ_TRUE    FileType = 1
 	GZ_FALSE   FileType = 0
 	GZ_UNKNOWN FileType = -1
+	ZSTD       FileType = 2
+	XZ         FileType = 3
+	BZIP2      FileType = 4
+	LZ4        FileType = 5
 )
 
 const (
-	DEFAULT_BUFSIZE = 128 * 1024 * 1024
+	DEFAULT_BUFSIZE      = 128 * 1024 * 1024
+	DEFAULT_GZ_BLOCKSIZE = 1024 * 1024
 )
 
 type File struct {
 	Path string
-	File *os.File
-	mode int
-	gz   FileType
+	// The underlying *os.File. This is nil for files that are not
+	// opened from the OS filesystem.
+	File   *os.File
+	mode   int
+	gz     FileType
+	handle FileHandle
+	fs     FS
+	lock   LockType
+
+	progress         ProgressFunc
+	progressInterval time.Duration
 }
 
 type IWriter interface {
@@ -43,54 +57,63 @@ type IWriter interface {
 type Writer struct {
 	IWriter
 	gz FileType
+	// The compressing writer. This is the same as IWriter unless
+	// the writer was buffered
+	stream IWriter
 }
 
 func (f *File) fixMode() {
-	// First, the simple case
-	if strings.HasSuffix(f.Path, ".gz") {
-		f.gz = GZ_TRUE
-	} else {
-		// Remember, all of this only occurs when gz is set to GZ_UNKNOWN
-		// So if a file is in write mode, has a non .gz suffix and is
-		// set to GZ_UNKNOWN, we're obviously going to give back a regular
-		// non-gz file
-		f.gz = GZ_FALSE
-
-		// Try to get a reader to figure it out
-		if f.mode|os.O_RDONLY|os.O_RDWR != 0 {
-			// We have read privilege..try to get a gzip reader
-			reader, err := gzip.NewReader(f.File)
-			if err == nil {
-				f.gz = GZ_TRUE
-				defer reader.Close()
-			} else {
-				f.gz = GZ_FALSE
-			}
-		}
+	// Remember, all of this only occurs when gz is set to GZ_UNKNOWN.
+	// Whatever the file is named, its contents have the final say.
+	// So if we can read, sniff the header for a known magic
+	readable := f.mode&(os.O_WRONLY|os.O_RDWR) != os.O_WRONLY
+	if readable {
+		header := make([]byte, sniffLen())
+		n, _ := io.ReadFull(f.handle, header)
 		// We can freely seek at this point
 		// This occurs on Open at which point the user is just
 		// opening the file and cannot do any operation on it.
 		// So, we can seek back and return as Open always does
 		// - at the start of the file
-		f.File.Seek(0, os.SEEK_SET)
+		f.handle.Seek(0, os.SEEK_SET)
+		if n > 0 {
+			f.gz = SniffFileType(header[:n])
+			return
+		}
+	}
+
+	// The file is either empty or we're not allowed to read it.
+	// Fall back to the suffix. A file in write mode that has no known
+	// suffix and is set to GZ_UNKNOWN gets back a regular plain file
+	if ft, ok := FileTypeFromPath(f.Path); ok {
+		f.gz = ft
+	} else {
+		f.gz = GZ_FALSE
 	}
 }
 
 func (w *Writer) Flush() (err error) {
-	if w.gz == GZ_TRUE {
-		v, _ := w.IWriter.(*gzip.Writer)
-		err = v.Flush()
-	} else {
-		v, _ := w.IWriter.(*bufio.Writer)
-		err = v.Flush()
+	if err = w.IWriter.Flush(); err != nil {
+		return
+	}
+	if w.stream != nil && w.stream != w.IWriter {
+		err = w.stream.Flush()
 	}
 	return
 }
 
 func (w *Writer) Close() (err error) {
-	if w.gz == GZ_TRUE {
-		v, _ := w.IWriter.(*gzip.Writer)
-		err = v.Close()
+	if w.stream != nil && w.stream != w.IWriter {
+		// Drain the buffer into the compressor before closing it
+		if err = w.IWriter.Flush(); err != nil {
+			return
+		}
+	}
+	if closer, ok := w.stream.(io.Closer); ok {
+		err = closer.Close()
+	} else if w.stream != nil {
+		// Plain files have nothing to close but may still be buffered
+		err = w.stream.Flush()
 	}
 	return
 }
@@ -100,25 +123,19 @@ func (f *File) Error() string {
 }
 
 func (f *File) RawReader() (io.Reader, error) {
-	gz_open := false
-	var reader io.Reader
-	var err error
+	return f.rawReaderFrom(f.handle)
+}
 
-	switch f.gz {
-	case GZ_TRUE:
-		gz_open = true
-	case GZ_FALSE:
-		// Nothing to do
-	case GZ_UNKNOWN:
+// rawReaderFrom decompresses src which holds the contents of f
+func (f *File) rawReaderFrom(src io.Reader) (io.Reader, error) {
+	if f.gz == GZ_UNKNOWN {
 		panic("Should not have occured..mode should have been fixed on open")
 	}
-
-	if gz_open == true {
-		reader, err = gzip.NewReader(f.File)
-	} else {
-		reader = bufio.NewReader(f.File)
+	codec, ok := GetCodec(f.gz)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("No codec registered for FileType %d", f.gz))
 	}
-	return reader, err
+	return codec.NewReader(src)
 }
 
 func (f *File) Reader(bufsize int) (*bufio.Scanner, error) {
@@ -126,7 +143,10 @@ func (f *File) Reader(bufsize int) (*bufio.Scanner, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newScanner(reader, bufsize), err
+}
 
+func newScanner(reader io.Reader, bufsize int) *bufio.Scanner {
 	scanner := bufio.NewScanner(reader)
 
 	var buf []byte
@@ -137,22 +157,89 @@ func (f *File) Reader(bufsize int) (*bufio.Scanner, error) {
 	}
 
 	scanner.Buffer(buf, bufsize)
-	return scanner, err
+	return scanner
 }
 
 func (f *File) AsyncReadWithBufsize(splitFunction bufio.SplitFunc, bufsize int, channel chan string) {
-	defer close(channel)
-	reader, err := f.Reader(bufsize)
+	err := f.asyncRead(context.Background(), splitFunction, bufsize, channel, PROGRESS_READ)
+	if readErr, ok := err.(*ReadError); ok && readErr.Line == 0 {
+		fmt.Fprintln(os.Stderr, "Failed to get reader")
+	}
+}
+
+// AsyncReadContext is like AsyncReadWithBufsize but stops when ctx is
+// done and returns why it stopped. It returns nil once every token has
+// been sent and a *ReadError otherwise. channel is closed either way, so
+// a consumer that stops reading early should cancel ctx to release it.
+func (f *File) AsyncReadContext(ctx context.Context, splitFunction bufio.SplitFunc, bufsize int, channel chan<- string) error {
+	return f.asyncRead(ctx, splitFunction, bufsize, channel, PROGRESS_READ)
+}
+
+// asyncRead reports progress, if any, as phase
+func (f *File) asyncRead(ctx context.Context, splitFunction bufio.SplitFunc, bufsize int, channel chan<- string, phase string) error {
+	var reader *bufio.Scanner
+	tracker := f.newProgressTracker(phase)
+	raw, err := f.rawReaderFrom(tracker.wrap(f.handle))
+	if err == nil {
+		reader = newScanner(raw, bufsize)
+	}
+	return scanContext(ctx, f.Path, reader, err, splitFunction, channel, tracker)
+}
+
+// ReadError is returned when a file could not be read to the end
+type ReadError struct {
+	Path string
+	// The line that could not be read, starting at 1. Every line before it
+	// has been delivered. 0 if reading never started.
+	Line int64
+	Err  error
+}
+
+func (e *ReadError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("Failed to read '%v': %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("Failed to read '%v' at line %d: %v", e.Path, e.Line, e.Err)
+}
+
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// asyncScan sends every token of reader to channel and closes it.
+// tracker may be nil.
+func asyncScan(reader *bufio.Scanner, err error, splitFunction bufio.SplitFunc, channel chan string, tracker *progressTracker) {
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to get reader")
-		return
+	}
+	scanContext(context.Background(), "", reader, err, splitFunction, channel, tracker)
+}
+
+// scanContext is asyncScan that stops when ctx is done and returns errors
+func scanContext(ctx context.Context, name string, reader *bufio.Scanner, err error, splitFunction bufio.SplitFunc, channel chan<- string, tracker *progressTracker) error {
+	defer close(channel)
+	if err != nil {
+		return &ReadError{name, 0, err}
 	}
 
 	reader.Split(splitFunction)
-	for reader.Scan() {
-		line := reader.Text()
-		channel <- line
+	var lines int64
+	for ctx.Err() == nil && reader.Scan() {
+		select {
+		case channel <- reader.Text():
+			lines++
+			tracker.addLines(1)
+		case <-ctx.Done():
+		}
+	}
+	if err = ctx.Err(); err == nil {
+		err = reader.Err()
 	}
+	if err != nil {
+		return &ReadError{name, lines + 1, err}
+	}
+	tracker.finish()
+	return nil
 }
 
 func (f *File) AsyncRead(splitFunction bufio.SplitFunc, channel chan string) {
@@ -160,46 +247,86 @@ func (f *File) AsyncRead(splitFunction bufio.SplitFunc, channel chan string) {
 }
 
 func (f *File) Writer(bufsize int) (Writer, error) {
-	gz_open := false
-	var writer IWriter
-	var err error
+	stream, err := f.streamTo(f.handle)
+	if err != nil {
+		return Writer{}, err
+	}
+	return newWriter(stream, f.gz, bufsize), err
+}
 
-	switch f.gz {
-	case GZ_TRUE:
-		gz_open = true
-	case GZ_FALSE:
-		// Nothing to do
-	default:
+// streamTo returns the compressor for f writing to dst
+func (f *File) streamTo(dst io.Writer) (IWriter, error) {
+	if f.gz == GZ_UNKNOWN {
 		panic("Should not have occured..mode should have been fixed on open")
 	}
+	codec, ok := GetCodec(f.gz)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("No codec registered for FileType %d", f.gz))
+	}
+	if codec.NewWriter == nil {
+		return nil, errors.New(fmt.Sprintf("Writing is not supported for '%v' files", codec.Name))
+	}
+	return codec.NewWriter(dst)
+}
+
+// ParallelWriter returns a Writer to a gzip file that compresses blocks
+// of blockSize bytes on up to workers goroutines at a time.
+// The output is a regular gzip stream and can be read by RawReader.
+// A blockSize or workers of 0 picks a default.
+func (f *File) ParallelWriter(bufsize int, blockSize int, workers int) (Writer, error) {
+	if f.gz != GZ_TRUE {
+		return Writer{}, errors.New(fmt.Sprintf("Parallel writing is only supported for gzip files: %v", f.Path))
+	}
+	if blockSize == 0 {
+		blockSize = DEFAULT_GZ_BLOCKSIZE
+	}
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
 
-	if gz_open == true {
-		writer = gzip.NewWriter(f.File)
-	} else {
-		writer = bufio.NewWriter(f.File)
+	stream := pgzip.NewWriter(f.handle)
+	if err := stream.SetConcurrency(blockSize, workers); err != nil {
+		return Writer{}, errors.New(fmt.Sprintf("Failed to set concurrency: %v", err))
 	}
+	return newWriter(stream, f.gz, bufsize), nil
+}
 
+func newWriter(stream IWriter, gz FileType, bufsize int) Writer {
+	writer := stream
 	if bufsize != 0 {
-		writer = bufio.NewWriterSize(writer, bufsize)
+		writer = bufio.NewWriterSize(stream, bufsize)
 	}
-	return Writer{writer, f.gz}, err
+	return Writer{writer, gz, stream}
 }
 
 func (f *File) Close() {
-	f.File.Close()
+	// Closing the file also releases any lock held on it
+	f.handle.Close()
+	f.lock = LOCK_NONE
 }
 
 func (f *File) Seek(offset int64, whence int) (int64, error) {
-	return f.File.Seek(offset, whence)
+	return f.handle.Seek(offset, whence)
+}
+
+// Handle returns the open file that File reads from and writes to
+func (f *File) Handle() FileHandle {
+	return f.handle
 }
 
 func Open(filepath string, mode int, gz FileType) (*File, error) {
+	return OpenFS(OS, filepath, mode, gz)
+}
+
+// OpenFS is like Open but opens filepath in fsys
+func OpenFS(fsys FS, filepath string, mode int, gz FileType) (*File, error) {
 	var retfile *File
 	var err error
 
-	file, err := os.OpenFile(filepath, mode, 0664)
+	handle, err := fsys.OpenFile(filepath, mode, 0664)
 	if err == nil {
-		retfile = &File{filepath, file, mode, gz}
+		file, _ := handle.(*os.File)
+		retfile = &File{Path: filepath, File: file, mode: mode, gz: gz, handle: handle, fs: fsys}
 		if gz == GZ_UNKNOWN {
 			retfile.fixMode()
 		}
@@ -208,82 +335,149 @@ func Open(filepath string, mode int, gz FileType) (*File, error) {
 }
 
 func ListFiles(fpath string, patterns []string) (matches []string, err error) {
-	_, err = os.Stat(fpath)
+	return ListFilesFS(OS, fpath, patterns)
+}
+
+// ListFilesFS is like ListFiles but lists files in fsys
+func ListFilesFS(fsys FS, fpath string, patterns []string) (matches []string, err error) {
+	return listFiles(fsys, fpath, patterns, "")
+}
+
+// ListFilesIgnoring is like ListFiles but skips paths excluded by the
+// gitignore-style files called ignoreFile found in the tree
+func ListFilesIgnoring(fpath string, patterns []string, ignoreFile string) (matches []string, err error) {
+	return ListFilesIgnoringFS(OS, fpath, patterns, ignoreFile)
+}
+
+// ListFilesIgnoringFS is like ListFilesIgnoring but lists files in fsys
+func ListFilesIgnoringFS(fsys FS, fpath string, patterns []string, ignoreFile string) (matches []string, err error) {
+	return listFiles(fsys, fpath, patterns, ignoreFile)
+}
+
+func listFiles(fsys FS, fpath string, patterns []string, ignoreFile string) (matches []string, err error) {
+	stat, err := fsys.Stat(fpath)
 	if err != nil {
 		return nil, err
 	}
+	if !stat.IsDir() {
+		if matched, err := matchName(patterns, stat.Name()); err != nil || !matched {
+			return nil, err
+		}
+		return []string{fpath}, nil
+	}
 
-	visit := func(fp string, fi os.FileInfo, err error) error {
+	opts := ListOptions{Hidden: true, IgnoreFile: ignoreFile, OnError: printListError}
+	files, err := ListFS(fsys, fpath, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		matched, err := matchName(patterns, filepath.Base(file))
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			return nil
-		}
-		if fi.IsDir() {
-			return nil
-		}
-		var matched bool
-		for _, pattern := range patterns {
-			var m bool
-			m, err = filepath.Match(pattern, fi.Name())
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				return err
-			}
-			matched = m || matched
+			return nil, err
 		}
 		if matched {
-			matches = append(matches, fp)
+			matches = append(matches, file)
+		}
+	}
+	return
+}
+
+func printListError(path string, err error) error {
+	fmt.Fprintln(os.Stderr, err)
+	return nil
+}
+
+// matchName reports whether name matches any of patterns
+func matchName(patterns []string, name string) (matched bool, err error) {
+	for _, pattern := range patterns {
+		var m bool
+		if m, err = filepath.Match(pattern, name); err != nil {
+			return false, err
 		}
-		return nil
+		matched = m || matched
 	}
-	filepath.Walk(fpath, visit)
-	sort.Strings(matches)
 	return
 }
 
 func IsDir(path string) (bool, error) {
-	fileInfo, err := os.Stat(path)
+	return IsDirFS(OS, path)
+}
+
+// IsDirFS is like IsDir but checks path in fsys
+func IsDirFS(fsys FS, path string) (bool, error) {
+	fileInfo, err := fsys.Stat(path)
+	if err != nil {
+		return false, err
+	}
 	return fileInfo.IsDir(), err
 }
 
 func ListDirs(fpath string, patterns []string) (matches []string, err error) {
-	var dirs []string
 	abs, _ := filepath.Abs(fpath)
-	for _, pattern := range patterns {
-		globPattern := abs + "/" + pattern
-		if dirs, err = doublestar.Glob(globPattern); err != nil {
-			err = errors.New(fmt.Sprintf("Failed to glob: %v", err))
-			return
-		}
-		for _, d := range dirs {
-			if ok, _ := IsDir(d); ok {
-				matches = append(matches, d)
-			}
-		}
+	return ListDirsFS(OS, abs, patterns)
+}
+
+// ListDirsFS is like ListDirs but lists directories in fsys.
+// The patterns are matched against the path of each directory relative
+// to fpath. Returned paths are joined to fpath.
+func ListDirsFS(fsys FS, fpath string, patterns []string) (matches []string, err error) {
+	return listDirs(fsys, fpath, patterns, "")
+}
+
+// ListDirsIgnoring is like ListDirs but skips directories excluded by
+// the gitignore-style files called ignoreFile found in the tree
+func ListDirsIgnoring(fpath string, patterns []string, ignoreFile string) (matches []string, err error) {
+	abs, _ := filepath.Abs(fpath)
+	return ListDirsIgnoringFS(OS, abs, patterns, ignoreFile)
+}
+
+// ListDirsIgnoringFS is like ListDirsIgnoring but lists directories in fsys
+func ListDirsIgnoringFS(fsys FS, fpath string, patterns []string, ignoreFile string) (matches []string, err error) {
+	return listDirs(fsys, fpath, patterns, ignoreFile)
+}
+
+func listDirs(fsys FS, fpath string, patterns []string, ignoreFile string) (matches []string, err error) {
+	if _, err = fsys.Stat(fpath); err != nil {
+		return nil, err
 	}
-	sort.Strings(matches)
-	return
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	// Only directories are ever matched. A trailing slash says as much.
+	opts := ListOptions{Type: LIST_DIRS, Include: patterns, Hidden: true, IgnoreFile: ignoreFile, OnError: printListError}
+	return ListFS(fsys, fpath, opts)
 }
 
 func Exists(path string) (bool, error) {
-	_, err := os.Stat(path)
+	return ExistsFS(OS, path)
+}
+
+// ExistsFS is like Exists but checks path in fsys
+func ExistsFS(fsys FS, path string) (bool, error) {
+	_, err := fsys.Stat(path)
 	if err == nil {
 		return true, nil
 	}
-	if os.IsNotExist(err) {
+	if errors.Is(err, fs.ErrNotExist) {
 		return false, nil
 	}
 	return true, err
 }
 
 func Makedirs(path string) error {
-	exist, err := Exists(path)
+	return MakedirsFS(OS, path)
+}
+
+// MakedirsFS is like Makedirs but creates the directories in fsys
+func MakedirsFS(fsys FS, path string) error {
+	exist, err := ExistsFS(fsys, path)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return err
 	}
 	if !exist {
-		return os.MkdirAll(path, 0775)
+		return fsys.MkdirAll(path, 0775)
 	}
 	return nil
 }
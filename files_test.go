@@ -2,14 +2,20 @@ package gocommons
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func CheckFileContentsMatch(f *File, contents string, expected bool) (bool, error) {
@@ -100,6 +106,112 @@ func TestWriteGz(t *testing.T) {
 	}
 }
 
+func TestWriteZstd(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	var success bool
+	var err error
+	var f *File
+	var writer Writer
+
+	f, err = Open("/tmp/write-zstd.zst", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, ZSTD)
+	assert.Nil(err, "Failed to open valid file", err)
+
+	writer, err = f.Writer(0)
+	assert.Nil(err, "Failed to get writer to file", err)
+
+	writer.Write([]byte("Hello World"))
+	writer.Flush()
+	writer.Close()
+	f.Close()
+
+	// Reopen with GZ_UNKNOWN to make sure the suffix is detected
+	f, err = Open("/tmp/write-zstd.zst", os.O_RDONLY, GZ_UNKNOWN)
+	assert.Nil(err, "Failed to open valid file", err)
+	defer os.Remove(f.Path)
+	defer f.Close()
+	assert.Equal(ZSTD, f.gz, "Failed to detect zstd file")
+
+	if success, err = CheckFileContentsMatch(f, "Hello World", true); err != nil || !success {
+		assert.Fail(fmt.Sprintf("Failed to verify file contents: %v", err))
+	}
+}
+
+func TestBufferedWriter(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	var success bool
+	var err error
+	var f *File
+
+	for _, gz := range []FileType{GZ_FALSE, GZ_TRUE, ZSTD, LZ4} {
+		f, err = Open("/tmp/buffered"+gz.Suffix(), os.O_CREATE|os.O_TRUNC|os.O_RDWR, gz)
+		assert.Nil(err, "Failed to open valid file")
+
+		writer, err := f.Writer(4096)
+		assert.Nil(err, "Failed to get writer to file")
+		writer.Write([]byte("stuff"))
+		writer.Flush()
+		writer.Close()
+
+		f.Seek(0, 0)
+		if success, err = CheckFileContentsMatch(f, "stuff", true); err != nil || !success {
+			assert.Fail(fmt.Sprintf("Failed to verify file contents(%v): %v", gz, err))
+		}
+		f.Close()
+		os.Remove(f.Path)
+	}
+}
+
+func TestParallelWriter(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	var err error
+	var f *File
+	var writer Writer
+
+	f, err = Open("/tmp/parallel-writer.gz", os.O_RDWR|os.O_CREATE|os.O_TRUNC, GZ_UNKNOWN)
+	assert.Nil(err, "Failed to open valid file", err)
+	defer os.Remove(f.Path)
+	defer f.Close()
+
+	// Use small blocks so that many of them are compressed concurrently
+	writer, err = f.ParallelWriter(4096, 64*1024, 4)
+	assert.Nil(err, "Failed to get parallel writer", err)
+
+	expected := make([]string, 0)
+	for i := 0; i < 100000; i++ {
+		line := fmt.Sprintf("line-%d", i)
+		expected = append(expected, line)
+		writer.Write([]byte(line + "\n"))
+	}
+	assert.Nil(writer.Flush(), "Failed to flush")
+	assert.Nil(writer.Close(), "Failed to close")
+
+	f.Seek(0, 0)
+	channel := make(chan string, 1000)
+	go f.AsyncRead(bufio.ScanLines, channel)
+	got := make([]string, 0)
+	for line := range channel {
+		got = append(got, line)
+	}
+	assert.Equal(expected, got, "Lines did not match")
+
+	// Only gzip is supported
+	f, err = Open("/tmp/parallel-writer.txt", os.O_RDWR|os.O_CREATE|os.O_TRUNC, GZ_FALSE)
+	assert.Nil(err, "Failed to open valid file", err)
+	defer os.Remove(f.Path)
+	defer f.Close()
+	_, err = f.ParallelWriter(0, 0, 0)
+	assert.NotNil(err, "Should have failed to get parallel writer for plain file")
+}
+
 func TestFlush(t *testing.T) {
 	t.Parallel()
 
@@ -238,17 +350,172 @@ func TestOpenGzUnknown(t *testing.T) {
 		assert.Fail(fmt.Sprintf("Failed to verify file contents: %v", err))
 	}
 
-	// Should fail
+	// Should pass
+	f, err = Open("test_files/open-test.zst", os.O_RDONLY, GZ_UNKNOWN)
+	assert.Nil(err, "Failed to open valid file", err)
+
+	success, err = CheckFileContentsMatch(f, "Hello World", true)
+	if err != nil || !success {
+		assert.Fail(fmt.Sprintf("Failed to verify file contents: %v", err))
+	}
+
+	// Should pass
+	f, err = Open("test_files/open-test.lz4", os.O_RDONLY, GZ_UNKNOWN)
+	assert.Nil(err, "Failed to open valid file", err)
+
+	success, err = CheckFileContentsMatch(f, "Hello World", true)
+	if err != nil || !success {
+		assert.Fail(fmt.Sprintf("Failed to verify file contents: %v", err))
+	}
+
+	// Should pass. This is a plain file with a .gz suffix and the
+	// contents win over the suffix
 	f, err = Open("test_files/open-test.fake.gz", os.O_RDONLY, GZ_UNKNOWN)
 	assert.Nil(err, "Failed to open valid file", err)
+	assert.Equal(GZ_FALSE, f.gz, "Should have sniffed plain file")
 
 	success, err = CheckFileContentsMatch(f, "Hello World", true)
-	if err == nil || success {
-		assert.Fail(fmt.Sprintf("Should have failed to verify file contents: %v", err))
+	if err != nil || !success {
+		assert.Fail(fmt.Sprintf("Failed to verify file contents: %v", err))
 	}
 
 }
 
+func TestOpenReadOnlyTypes(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	var success bool
+	var err error
+	var f *File
+
+	for _, gz := range []FileType{XZ, BZIP2} {
+		path := "test_files/open-test" + gz.Suffix()
+
+		// Should pass
+		f, err = Open(path, os.O_RDONLY, gz)
+		assert.Nil(err, "Failed to open valid file", err)
+
+		success, err = CheckFileContentsMatch(f, "Hello World", true)
+		if err != nil || !success {
+			assert.Fail(fmt.Sprintf("Failed to verify file contents(%v): %v", path, err))
+		}
+		f.Close()
+
+		// Should be detected from the suffix
+		f, err = Open(path, os.O_RDONLY, GZ_UNKNOWN)
+		assert.Nil(err, "Failed to open valid file", err)
+		assert.Equal(gz, f.gz, "Failed to detect file type")
+
+		channel := make(chan string)
+		go f.AsyncRead(bufio.ScanLines, channel)
+		lines := make([]string, 0)
+		for line := range channel {
+			lines = append(lines, line)
+		}
+		assert.Equal([]string{"Hello World"}, lines, "Failed to read lines")
+
+		// Writing is not supported
+		_, err = f.Writer(0)
+		assert.NotNil(err, "Should have failed to get writer")
+		f.Close()
+	}
+}
+
+func TestAsyncReadContext(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	readAll := func(ctx context.Context, path string, gz FileType, bufsize int) (lines []string, err error) {
+		f, err := Open(path, os.O_RDONLY, gz)
+		require.Nil(err)
+		defer f.Close()
+		channel := make(chan string)
+		errc := make(chan error)
+		go func() {
+			errc <- f.AsyncReadContext(ctx, bufio.ScanLines, bufsize, channel)
+		}()
+		for line := range channel {
+			lines = append(lines, line)
+		}
+		return lines, <-errc
+	}
+
+	lines, err := readAll(context.Background(), "./test_files/open-test.gz", GZ_UNKNOWN, 0)
+	assert.Nil(err)
+	assert.Equal([]string{"Hello World"}, lines)
+
+	// Line too long for the buffer
+	path := "/tmp/async-read-context-long.txt"
+	require.Nil(os.WriteFile(path, []byte("short\nshort\n"+strings.Repeat("x", 100)+"\nshort\n"), 0664))
+	defer os.Remove(path)
+	lines, err = readAll(context.Background(), path, GZ_FALSE, 16)
+	assert.Equal([]string{"short", "short"}, lines)
+	var readErr *ReadError
+	require.True(errors.As(err, &readErr), "Expected a ReadError: %v", err)
+	assert.True(errors.Is(err, bufio.ErrTooLong))
+	assert.Equal(int64(3), readErr.Line)
+	assert.Equal(path, readErr.Path)
+
+	// Truncated gzip
+	data := gzipTestData(2000)
+	path = "/tmp/async-read-context-truncated.gz"
+	writeGzipTestFile(t, path, data)
+	defer os.Remove(path)
+	stat, _ := os.Stat(path)
+	os.Truncate(path, stat.Size()/2)
+	lines, err = readAll(context.Background(), path, GZ_UNKNOWN, 0)
+	require.True(errors.As(err, &readErr), "Expected a ReadError: %v", err)
+	assert.True(errors.Is(err, io.ErrUnexpectedEOF), "%v", err)
+	assert.Equal(int64(len(lines)+1), readErr.Line)
+
+	// No reader at all
+	lines, err = readAll(context.Background(), "./test_files/open-test.txt", FileType(99), 0)
+	require.True(errors.As(err, &readErr), "Expected a ReadError: %v", err)
+	assert.Equal(int64(0), readErr.Line)
+	assert.Nil(lines)
+}
+
+func TestAsyncReadContextCancel(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := "/tmp/async-read-context-cancel.gz"
+	writeGzipTestFile(t, path, gzipTestData(10000))
+	defer os.Remove(path)
+	f, err := Open(path, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	channel := make(chan string)
+	errc := make(chan error)
+	go func() {
+		errc <- f.AsyncReadContext(ctx, bufio.ScanLines, 0, channel)
+	}()
+	for i := 0; i < 10; i++ {
+		<-channel
+	}
+	// Stop reading. The producer must not stay blocked on the channel
+	cancel()
+	select {
+	case err = <-errc:
+	case <-time.After(5 * time.Second):
+		t.Fatal("AsyncReadContext did not return after cancel")
+	}
+	assert.True(errors.Is(err, context.Canceled), "%v", err)
+	var readErr *ReadError
+	require.True(errors.As(err, &readErr))
+	assert.Equal(int64(11), readErr.Line)
+	_, ok := <-channel
+	assert.False(ok, "Channel should be closed")
+}
+
 func TestListFiles(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,559 @@
+package gocommons
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FS is a filesystem that files can be opened from and written to.
+// It is an io/fs.FS and so works with fs.WalkDir, fs.Glob and friends.
+// Unlike io/fs, names may be absolute or relative OS-style paths
+// using forward slashes.
+type FS interface {
+	fs.StatFS
+	fs.ReadDirFS
+	OpenFile(name string, flag int, perm os.FileMode) (FileHandle, error)
+	MkdirAll(name string, perm os.FileMode) error
+	Remove(name string) error
+	Rename(oldname string, newname string) error
+}
+
+// FileHandle is an open file of an FS
+type FileHandle interface {
+	fs.File
+	io.Writer
+	io.Seeker
+	io.ReaderAt
+	Sync() error
+}
+
+// OS is the FS of the operating system.
+// It is what Open, ListFiles, ListDirs, Exists and Makedirs use.
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (FileHandle, error) {
+	file, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		// Don't hand back a nil *os.File wrapped in an interface
+		return nil, err
+	}
+	return file, nil
+}
+
+func (osFS) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldname string, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+// MemFS is an FS that lives entirely in memory. Absolute and relative
+// names refer to the same tree, so "/a/b" and "a/b" are the same file.
+// Names are cleaned and names that climb above the root are invalid.
+// The zero value is an empty MemFS.
+type MemFS struct {
+	mutex sync.RWMutex
+	once  sync.Once
+	nodes map[string]*memNode
+}
+
+type memNode struct {
+	name    string
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (n *memNode) info() fs.FileInfo {
+	return &memFileInfo{path.Base(n.name), int64(len(n.data)), n.mode, n.modTime}
+}
+
+// NewMemFS returns an empty MemFS
+func NewMemFS() *MemFS {
+	return &MemFS{}
+}
+
+// lazyInit creates the root directory on first use
+func (mfs *MemFS) lazyInit() {
+	mfs.once.Do(func() {
+		mfs.nodes = make(map[string]*memNode)
+		mfs.nodes["."] = &memNode{name: ".", mode: fs.ModeDir | 0775, modTime: time.Now()}
+	})
+}
+
+// memName maps name to the key of its node
+func memName(op string, name string) (string, error) {
+	clean := path.Clean(strings.TrimLeft(name, "/"))
+	if clean == ".." || strings.HasPrefix(clean, "../") || !fs.ValidPath(clean) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return clean, nil
+}
+
+func (mfs *MemFS) parentDir(op string, name string) error {
+	if parent, ok := mfs.nodes[path.Dir(name)]; !ok || !parent.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+func (mfs *MemFS) Open(name string) (fs.File, error) {
+	return mfs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (mfs *MemFS) Stat(name string) (fs.FileInfo, error) {
+	name, err := memName("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	mfs.lazyInit()
+	mfs.mutex.RLock()
+	defer mfs.mutex.RUnlock()
+	node, ok := mfs.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return node.info(), nil
+}
+
+func (mfs *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	name, err := memName("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	mfs.lazyInit()
+	mfs.mutex.RLock()
+	defer mfs.mutex.RUnlock()
+	node, ok := mfs.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	if !node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	entries := make([]fs.DirEntry, 0)
+	for childName, child := range mfs.nodes {
+		if childName == "." || !strings.HasPrefix(childName, prefix) {
+			continue
+		}
+		if strings.Contains(childName[len(prefix):], "/") {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(child.info()))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (mfs *MemFS) OpenFile(name string, flag int, perm os.FileMode) (FileHandle, error) {
+	name, err := memName("open", name)
+	if err != nil {
+		return nil, err
+	}
+	mfs.lazyInit()
+	mfs.mutex.Lock()
+	defer mfs.mutex.Unlock()
+
+	node, ok := mfs.nodes[name]
+	if ok && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		if err := mfs.parentDir("open", name); err != nil {
+			return nil, err
+		}
+		node = &memNode{name: name, mode: perm & fs.ModePerm, modTime: time.Now()}
+		mfs.nodes[name] = node
+	}
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if node.mode.IsDir() && writable {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+	if flag&os.O_TRUNC != 0 && writable {
+		node.data = node.data[:0]
+		node.modTime = time.Now()
+	}
+	return &memHandle{fs: mfs, node: node, flag: flag}, nil
+}
+
+func (mfs *MemFS) MkdirAll(name string, perm os.FileMode) error {
+	name, err := memName("mkdir", name)
+	if err != nil {
+		return err
+	}
+	mfs.lazyInit()
+	mfs.mutex.Lock()
+	defer mfs.mutex.Unlock()
+
+	var dirs []string
+	for dir := name; dir != "."; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if node, ok := mfs.nodes[dirs[i]]; ok {
+			if !node.mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: dirs[i], Err: errors.New("not a directory")}
+			}
+			continue
+		}
+		mfs.nodes[dirs[i]] = &memNode{name: dirs[i], mode: fs.ModeDir | perm&fs.ModePerm, modTime: time.Now()}
+	}
+	return nil
+}
+
+func (mfs *MemFS) Remove(name string) error {
+	name, err := memName("remove", name)
+	if err != nil {
+		return err
+	}
+	mfs.lazyInit()
+	mfs.mutex.Lock()
+	defer mfs.mutex.Unlock()
+
+	node, ok := mfs.nodes[name]
+	if !ok || name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if node.mode.IsDir() {
+		for other := range mfs.nodes {
+			if strings.HasPrefix(other, name+"/") {
+				return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+			}
+		}
+	}
+	delete(mfs.nodes, name)
+	return nil
+}
+
+func (mfs *MemFS) Rename(oldname string, newname string) error {
+	oldname, err := memName("rename", oldname)
+	if err != nil {
+		return err
+	}
+	if newname, err = memName("rename", newname); err != nil {
+		return err
+	}
+	mfs.lazyInit()
+	mfs.mutex.Lock()
+	defer mfs.mutex.Unlock()
+
+	node, ok := mfs.nodes[oldname]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrNotExist}
+	}
+	if err := mfs.parentDir("rename", newname); err != nil {
+		return err
+	}
+	if existing, ok := mfs.nodes[newname]; ok && existing.mode.IsDir() {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrExist}
+	}
+	// Move the node and, for directories, everything under it
+	moved := []*memNode{node}
+	for other, child := range mfs.nodes {
+		if strings.HasPrefix(other, oldname+"/") {
+			moved = append(moved, child)
+		}
+	}
+	for _, child := range moved {
+		delete(mfs.nodes, child.name)
+		child.name = newname + child.name[len(oldname):]
+		mfs.nodes[child.name] = child
+	}
+	return nil
+}
+
+// WriteFile creates or truncates name and writes data to it
+func (mfs *MemFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	handle, err := mfs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+	_, err = handle.Write(data)
+	return err
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
+
+type memHandle struct {
+	fs     *MemFS
+	node   *memNode
+	flag   int
+	offset int64
+	closed bool
+	// Remaining entries for ReadDir
+	entries []fs.DirEntry
+}
+
+func (h *memHandle) check(op string, write bool) error {
+	if h.closed {
+		return &fs.PathError{Op: op, Path: h.node.name, Err: fs.ErrClosed}
+	}
+	if h.node.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: h.node.name, Err: errors.New("is a directory")}
+	}
+	accmode := h.flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)
+	if write && accmode == os.O_RDONLY || !write && accmode == os.O_WRONLY {
+		return &fs.PathError{Op: op, Path: h.node.name, Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+func (h *memHandle) Stat() (fs.FileInfo, error) {
+	if h.closed {
+		return nil, &fs.PathError{Op: "stat", Path: h.node.name, Err: fs.ErrClosed}
+	}
+	h.fs.mutex.RLock()
+	defer h.fs.mutex.RUnlock()
+	return h.node.info(), nil
+}
+
+func (h *memHandle) Read(b []byte) (int, error) {
+	n, err := h.ReadAt(b, h.offset)
+	h.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (h *memHandle) ReadAt(b []byte, offset int64) (int, error) {
+	if err := h.check("read", false); err != nil {
+		return 0, err
+	}
+	h.fs.mutex.RLock()
+	defer h.fs.mutex.RUnlock()
+	if offset >= int64(len(h.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, h.node.data[offset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (h *memHandle) Write(b []byte) (int, error) {
+	if err := h.check("write", true); err != nil {
+		return 0, err
+	}
+	h.fs.mutex.Lock()
+	defer h.fs.mutex.Unlock()
+	if h.flag&os.O_APPEND != 0 {
+		h.offset = int64(len(h.node.data))
+	}
+	if end := h.offset + int64(len(b)); end > int64(len(h.node.data)) {
+		h.node.data = append(h.node.data, make([]byte, end-int64(len(h.node.data)))...)
+	}
+	copy(h.node.data[h.offset:], b)
+	h.offset += int64(len(b))
+	h.node.modTime = time.Now()
+	return len(b), nil
+}
+
+func (h *memHandle) Seek(offset int64, whence int) (int64, error) {
+	if h.closed {
+		return 0, &fs.PathError{Op: "seek", Path: h.node.name, Err: fs.ErrClosed}
+	}
+	h.fs.mutex.RLock()
+	size := int64(len(h.node.data))
+	h.fs.mutex.RUnlock()
+	switch whence {
+	case io.SeekCurrent:
+		offset += h.offset
+	case io.SeekEnd:
+		offset += size
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: h.node.name, Err: fs.ErrInvalid}
+	}
+	h.offset = offset
+	return offset, nil
+}
+
+// ReadDir lets directories opened through MemFS.Open be listed
+func (h *memHandle) ReadDir(count int) ([]fs.DirEntry, error) {
+	if !h.node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: h.node.name, Err: errors.New("not a directory")}
+	}
+	if h.entries == nil {
+		entries, err := h.fs.ReadDir(h.node.name)
+		if err != nil {
+			return nil, err
+		}
+		h.entries = entries
+	}
+	if count <= 0 {
+		ret := h.entries
+		h.entries = h.entries[len(h.entries):]
+		return ret, nil
+	}
+	if len(h.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(h.entries) {
+		count = len(h.entries)
+	}
+	ret := h.entries[:count]
+	h.entries = h.entries[count:]
+	return ret, nil
+}
+
+func (h *memHandle) Sync() error {
+	return nil
+}
+
+func (h *memHandle) Close() error {
+	if h.closed {
+		return &fs.PathError{Op: "close", Path: h.node.name, Err: fs.ErrClosed}
+	}
+	h.closed = true
+	return nil
+}
+
+// ReadOnlyFS adapts an io/fs.FS such as an embed.FS to FS.
+// Files can only be opened with os.O_RDONLY and must support seeking,
+// which is the case for embed.FS and fstest.MapFS. Everything that
+// modifies the filesystem fails with fs.ErrPermission.
+func ReadOnlyFS(fsys fs.FS) FS {
+	return readOnlyFS{fsys}
+}
+
+type readOnlyFS struct {
+	fsys fs.FS
+}
+
+func (rfs readOnlyFS) Open(name string) (fs.File, error) {
+	clean, err := memName("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return rfs.fsys.Open(clean)
+}
+
+func (rfs readOnlyFS) Stat(name string) (fs.FileInfo, error) {
+	clean, err := memName("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(rfs.fsys, clean)
+}
+
+func (rfs readOnlyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	clean, err := memName("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadDir(rfs.fsys, clean)
+}
+
+func (rfs readOnlyFS) OpenFile(name string, flag int, perm os.FileMode) (FileHandle, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	clean, err := memName("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := rfs.fsys.Open(clean)
+	if err != nil {
+		return nil, err
+	}
+	seeker, ok := file.(io.ReadSeeker)
+	if !ok {
+		file.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("file does not support seeking")}
+	}
+	return &readOnlyHandle{File: file, seeker: seeker, name: name}, nil
+}
+
+func (rfs readOnlyFS) MkdirAll(name string, perm os.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrPermission}
+}
+
+func (rfs readOnlyFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+func (rfs readOnlyFS) Rename(oldname string, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrPermission}
+}
+
+type readOnlyHandle struct {
+	fs.File
+	seeker io.ReadSeeker
+	name   string
+}
+
+func (h *readOnlyHandle) Write(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: h.name, Err: fs.ErrPermission}
+}
+
+func (h *readOnlyHandle) Seek(offset int64, whence int) (int64, error) {
+	return h.seeker.Seek(offset, whence)
+}
+
+// ReadAt falls back to seeking when the file is not an io.ReaderAt.
+// The fallback moves the offset used by Read.
+func (h *readOnlyHandle) ReadAt(b []byte, offset int64) (int, error) {
+	if ra, ok := h.File.(io.ReaderAt); ok {
+		return ra.ReadAt(b, offset)
+	}
+	if _, err := h.seeker.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(h.seeker, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (h *readOnlyHandle) Sync() error {
+	return nil
+}
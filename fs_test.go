@@ -0,0 +1,209 @@
+package gocommons
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMemFS(t *testing.T) *MemFS {
+	require := require.New(t)
+
+	mfs := NewMemFS()
+	require.Nil(mfs.MkdirAll("/data/1/11/111", 0775))
+	require.Nil(mfs.MkdirAll("/data/2/21", 0775))
+	require.Nil(mfs.MkdirAll("/data/3/31", 0775))
+	require.Nil(mfs.WriteFile("/data/a.txt", []byte("a\n"), 0664))
+	require.Nil(mfs.WriteFile("/data/1/b.txt", []byte("b\n"), 0664))
+	require.Nil(mfs.WriteFile("/data/1/11/c.gz", []byte("c\n"), 0664))
+	return mfs
+}
+
+func TestMemFS(t *testing.T) {
+	t.Parallel()
+
+	// MemFS accepts OS-style names which fstest rejects. fs.Sub validates
+	// names before they reach MemFS.
+	sub, err := fs.Sub(newTestMemFS(t), "data")
+	require.Nil(t, err)
+	err = fstest.TestFS(sub, "a.txt", "1/b.txt", "1/11/c.gz", "2/21")
+	assert.Nil(t, err)
+}
+
+func TestMemFSOperations(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mfs := newTestMemFS(t)
+
+	_, err := mfs.OpenFile("/missing/x", os.O_WRONLY|os.O_CREATE, 0664)
+	assert.True(errors.Is(err, fs.ErrNotExist), "Should have failed without parent")
+	_, err = mfs.OpenFile("/data/a.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0664)
+	assert.True(errors.Is(err, fs.ErrExist), "Should have failed on O_EXCL")
+
+	h, err := mfs.OpenFile("/data/a.txt", os.O_WRONLY|os.O_APPEND, 0)
+	require.Nil(err)
+	h.Write([]byte("b\n"))
+	_, err = h.Read(make([]byte, 1))
+	assert.NotNil(err, "Should not be able to read write-only file")
+	h.Close()
+
+	h, err = mfs.OpenFile("/data/a.txt", os.O_RDONLY, 0)
+	require.Nil(err)
+	buf := make([]byte, 2)
+	n, err := h.ReadAt(buf, 2)
+	assert.Nil(err)
+	assert.Equal("b\n", string(buf[:n]))
+	data, err := io.ReadAll(h)
+	assert.Nil(err)
+	assert.Equal("a\nb\n", string(data))
+	h.Close()
+
+	require.Nil(mfs.Rename("/data/1", "/moved"))
+	exists, _ := ExistsFS(mfs, "/moved/11/c.gz")
+	assert.True(exists, "Rename should move children")
+	exists, _ = ExistsFS(mfs, "/data/1/11/c.gz")
+	assert.False(exists, "Rename should not leave children behind")
+
+	assert.NotNil(mfs.Remove("/data"), "Should not remove non-empty directory")
+	require.Nil(mfs.Remove("/data/a.txt"))
+	exists, _ = ExistsFS(mfs, "/data/a.txt")
+	assert.False(exists)
+}
+
+func TestMemFSNames(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// The zero value must be usable
+	var mfs MemFS
+	entries, err := mfs.ReadDir(".")
+	require.Nil(err)
+	assert.Equal(0, len(entries))
+
+	require.Nil(mfs.MkdirAll("a/../b", 0775))
+	require.Nil(mfs.WriteFile("/b/c.txt", []byte("c\n"), 0664))
+	for _, name := range []string{"b/c.txt", "/b/c.txt", "a/../b/c.txt", "b//./c.txt"} {
+		exists, err := ExistsFS(&mfs, name)
+		assert.Nil(err)
+		assert.True(exists, "%v should refer to b/c.txt", name)
+	}
+	entries, err = mfs.ReadDir("/")
+	require.Nil(err)
+	require.Equal(1, len(entries))
+	assert.Equal("b", entries[0].Name())
+
+	for _, name := range []string{"..", "../b/c.txt", "/../b/c.txt", "b/../../c.txt"} {
+		_, err := mfs.Stat(name)
+		assert.True(errors.Is(err, fs.ErrInvalid), "%v should be invalid", name)
+		err = mfs.WriteFile(name, []byte("x\n"), 0664)
+		assert.True(errors.Is(err, fs.ErrInvalid), "%v should be invalid", name)
+	}
+	assert.True(errors.Is(mfs.Rename("/b/c.txt", "../c.txt"), fs.ErrInvalid))
+}
+
+func TestOpenFS(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mfs := NewMemFS()
+	require.Nil(MakedirsFS(mfs, "/out/nested"))
+	for _, gz := range []FileType{GZ_FALSE, GZ_TRUE, ZSTD} {
+		path := "/out/nested/file" + gz.Suffix()
+		f, err := OpenFS(mfs, path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, gz)
+		require.Nil(err)
+		assert.Nil(f.File, "MemFS files have no *os.File")
+		writer, err := f.Writer(0)
+		require.Nil(err)
+		writer.Write([]byte("Hello World\n"))
+		require.Nil(writer.Close())
+		f.Close()
+
+		f, err = OpenFS(mfs, path, os.O_RDONLY, GZ_UNKNOWN)
+		require.Nil(err)
+		assert.Equal(gz, f.gz, "Failed to sniff %v", path)
+		ok, err := CheckFileContentsMatch(f, "Hello World", true)
+		assert.True(ok, "Contents of %v did not match: %v", path, err)
+		f.Close()
+	}
+
+	_, err := OpenFS(mfs, "/out/missing", os.O_RDONLY, GZ_UNKNOWN)
+	assert.NotNil(err)
+	ok, err := IsDirFS(mfs, "/out/missing")
+	assert.False(ok)
+	assert.NotNil(err)
+}
+
+func TestListFilesFS(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	mfs := newTestMemFS(t)
+	files, err := ListFilesFS(mfs, "/data", []string{"*.txt"})
+	assert.Nil(err)
+	assert.Equal([]string{"/data/1/b.txt", "/data/a.txt"}, files)
+
+	files, err = ListFilesFS(mfs, "/data", []string{"*.txt", "*.gz"})
+	assert.Nil(err)
+	assert.Equal([]string{"/data/1/11/c.gz", "/data/1/b.txt", "/data/a.txt"}, files)
+
+	_, err = ListFilesFS(mfs, "/missing", []string{"*"})
+	assert.NotNil(err)
+}
+
+func TestListDirsFS(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	mfs := newTestMemFS(t)
+	dirs, err := ListDirsFS(mfs, "/data", []string{"*/"})
+	assert.Nil(err)
+	assert.Equal([]string{"/data/1", "/data/2", "/data/3"}, dirs)
+
+	dirs, err = ListDirsFS(mfs, "/data", []string{"**/"})
+	assert.Nil(err)
+	assert.Equal([]string{"/data/1", "/data/1/11", "/data/1/11/111", "/data/2", "/data/2/21", "/data/3", "/data/3/31"}, dirs)
+
+	dirs, err = ListDirsFS(mfs, "/data", []string{"*/*1"})
+	assert.Nil(err)
+	assert.Equal([]string{"/data/1/11", "/data/2/21", "/data/3/31"}, dirs)
+}
+
+func TestReadOnlyFS(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rfs := ReadOnlyFS(fstest.MapFS{
+		"assets/hello.txt": {Data: []byte("Hello World\n")},
+		"assets/sub/x.txt": {Data: []byte("x\n")},
+	})
+	f, err := OpenFS(rfs, "assets/hello.txt", os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	ok, err := CheckFileContentsMatch(f, "Hello World", true)
+	assert.True(ok, "Contents did not match: %v", err)
+	f.Close()
+
+	files, err := ListFilesFS(rfs, "assets", []string{"*.txt"})
+	assert.Nil(err)
+	assert.Equal([]string{"assets/hello.txt", "assets/sub/x.txt"}, files)
+
+	_, err = OpenFS(rfs, "assets/new.txt", os.O_WRONLY|os.O_CREATE, GZ_FALSE)
+	assert.True(errors.Is(err, fs.ErrPermission), "Should not be able to write")
+	assert.NotNil(MakedirsFS(rfs, "assets/newdir"))
+}
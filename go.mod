@@ -0,0 +1,23 @@
+module github.com/gurupras/gocommons
+
+go 1.25
+
+require (
+	github.com/bmatcuk/doublestar v1.3.4
+	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510
+	github.com/klauspost/compress v1.20.1
+	github.com/klauspost/pgzip v1.2.7
+	github.com/pierrec/lz4/v4 v4.1.31
+	github.com/stretchr/testify v1.9.0
+	github.com/ulikunitz/xz v0.5.17
+	gopkg.in/vmihailenco/msgpack.v2 v2.9.2
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	google.golang.org/appengine v1.6.8 // indirect
+	google.golang.org/protobuf v1.26.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
@@ -0,0 +1,842 @@
+package gocommons
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"gopkg.in/vmihailenco/msgpack.v2"
+)
+
+// This file implements a zran-style index for gzip files.
+// While building the index, the file is decompressed once with a small
+// inflater that keeps track of where each deflate block begins. Every
+// span bytes of output, the bit offset of the next block and the 32K
+// of output preceding it are saved as an access point. Reading from an
+// access point starts the same inflater at that bit offset, primed with
+// the saved window.
+// compress/flate cannot be used for this as it has no way of starting
+// in the middle of a byte.
+
+const (
+	DEFAULT_GZ_INDEX_SPAN = 1024 * 1024
+	GZ_INDEX_SUFFIX       = ".gzidx"
+
+	gzWindowSize = 1 << 15
+)
+
+// GzipAccessPoint is a location in a gzip file at which decompression
+// can be started
+type GzipAccessPoint struct {
+	// Offset of the byte containing the first bit of the deflate block
+	In int64
+	// Number of low bits of that byte that belong to the previous block
+	Bits uint
+	// Uncompressed offset at the start of the block
+	Out int64
+	// Number of lines preceding Out
+	Line int64
+	// The uncompressed data preceding Out
+	Window []byte
+}
+
+// GzipIndex holds access points into a gzip file
+type GzipIndex struct {
+	Span           int64
+	CompressedSize int64
+	// Modification time of the file in nanoseconds
+	ModTime int64
+	// CRC32 and ISIZE of the last gzip member
+	Trailer []byte
+	Size    int64
+	Lines   int64
+	Points  []GzipAccessPoint
+}
+
+// GzipIndexPath returns the path of the sidecar index for a gzip file
+func GzipIndexPath(path string) string {
+	return path + GZ_INDEX_SUFFIX
+}
+
+// BuildGzipIndex decompresses the gzip file f and returns an index with
+// an access point roughly every span bytes of uncompressed data.
+// A span of 0 uses DEFAULT_GZ_INDEX_SPAN.
+func BuildGzipIndex(f *File, span int64) (*GzipIndex, error) {
+	if f.gz != GZ_TRUE {
+		return nil, errors.New(fmt.Sprintf("Can only index gzip files: %v", f.Path))
+	}
+	if span <= 0 {
+		span = DEFAULT_GZ_INDEX_SPAN
+	}
+
+	size, modTime, trailer, err := gzipFingerprint(f)
+	if err != nil {
+		return nil, err
+	}
+	idx := &GzipIndex{Span: span, CompressedSize: size, ModTime: modTime, Trailer: trailer}
+
+	in := newInflater(io.NewSectionReader(f.handle, 0, size))
+	in.onBlock = func() {
+		if len(idx.Points) == 0 || in.total-idx.Points[len(idx.Points)-1].Out >= span {
+			pos := in.bitPos()
+			idx.Points = append(idx.Points, GzipAccessPoint{
+				In:     pos / 8,
+				Bits:   uint(pos % 8),
+				Out:    in.total,
+				Line:   in.lines,
+				Window: in.window(),
+			})
+		}
+	}
+	if _, err = io.Copy(io.Discard, in); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to index '%v': %v", f.Path, err))
+	}
+	idx.Size = in.total
+	idx.Lines = in.lines
+	return idx, nil
+}
+
+// LoadGzipIndex reads an index saved by GzipIndex.Save
+func LoadGzipIndex(path string) (*GzipIndex, error) {
+	f, err := Open(path, os.O_RDONLY, GZ_TRUE)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader, err := f.RawReader()
+	if err != nil {
+		return nil, err
+	}
+	idx := new(GzipIndex)
+	if err = msgpack.NewDecoder(reader).Decode(idx); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to decode index '%v': %v", path, err))
+	}
+	return idx, nil
+}
+
+// LoadOrBuildGzipIndex loads the sidecar index of f if there is one
+// that matches the file. Otherwise, it builds a new index and saves it
+// as the sidecar.
+func LoadOrBuildGzipIndex(f *File, span int64) (*GzipIndex, error) {
+	path := GzipIndexPath(f.Path)
+	if exists, _ := Exists(path); exists {
+		if idx, err := LoadGzipIndex(path); err == nil && idx.check(f) == nil {
+			return idx, nil
+		}
+	}
+	idx, err := BuildGzipIndex(f, span)
+	if err != nil {
+		return nil, err
+	}
+	if err = idx.Save(path); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Save writes the index to path as a gzipped msgpack stream
+func (idx *GzipIndex) Save(path string) error {
+	f, err := Open(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, GZ_TRUE)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	writer, err := f.Writer(0)
+	if err != nil {
+		return err
+	}
+	if err = msgpack.NewEncoder(writer).Encode(idx); err != nil {
+		return errors.New(fmt.Sprintf("Failed to encode index: %v", err))
+	}
+	return writer.Close()
+}
+
+// gzipFingerprint returns what an index remembers about the file it
+// was built for
+func gzipFingerprint(f *File) (size int64, modTime int64, trailer []byte, err error) {
+	stat, err := f.handle.Stat()
+	if err != nil {
+		return
+	}
+	size = stat.Size()
+	modTime = stat.ModTime().UnixNano()
+	if size >= 8 {
+		trailer = make([]byte, 8)
+		if _, err = f.handle.ReadAt(trailer, size-8); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// check makes sure that the index was built for f
+func (idx *GzipIndex) check(f *File) error {
+	size, modTime, trailer, err := gzipFingerprint(f)
+	if err != nil {
+		return err
+	}
+	if size != idx.CompressedSize {
+		return errors.New(fmt.Sprintf("Index is stale. Expected %d bytes, '%v' has %d", idx.CompressedSize, f.Path, size))
+	}
+	if modTime != idx.ModTime || !bytes.Equal(trailer, idx.Trailer) {
+		return errors.New(fmt.Sprintf("Index is stale. '%v' has been modified", f.Path))
+	}
+	return nil
+}
+
+// SeekGzip returns a reader of the uncompressed data of the gzip file f
+// starting at the uncompressed offset.
+// The reader does not use the file's offset and f.Seek has no effect on it.
+func (f *File) SeekGzip(idx *GzipIndex, offset int64) (io.Reader, error) {
+	if offset < 0 || offset > idx.Size {
+		return nil, errors.New(fmt.Sprintf("Offset %d is out of range [0, %d]", offset, idx.Size))
+	}
+	point := idx.findPoint(func(p *GzipAccessPoint) bool { return p.Out <= offset })
+	reader, err := f.readFromPoint(idx, point)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.CopyN(io.Discard, reader, offset-point.Out); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to seek to offset %d: %v", offset, err))
+	}
+	return reader, nil
+}
+
+// SeekGzipLine returns a reader of the uncompressed data of the gzip file
+// f starting at the beginning of line. Lines are counted from 0.
+func (f *File) SeekGzipLine(idx *GzipIndex, line int64) (io.Reader, error) {
+	if line < 0 || line > idx.Lines {
+		return nil, errors.New(fmt.Sprintf("Line %d is out of range [0, %d]", line, idx.Lines))
+	}
+	// Access points rarely fall at the start of a line. So start from one
+	// that has at least one newline left to skip
+	point := idx.findPoint(func(p *GzipAccessPoint) bool { return p.Out == 0 || p.Line < line })
+	reader, err := f.readFromPoint(idx, point)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(reader)
+	for skip := line - point.Line; skip > 0; skip-- {
+		if _, err = buffered.ReadSlice('\n'); err == bufio.ErrBufferFull {
+			skip++
+			continue
+		} else if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to seek to line %d: %v", line, err))
+		}
+	}
+	return buffered, nil
+}
+
+// findPoint returns the last access point for which fn returns true.
+// fn must be true for a prefix of the access points.
+func (idx *GzipIndex) findPoint(fn func(p *GzipAccessPoint) bool) *GzipAccessPoint {
+	lo, hi := 0, len(idx.Points)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if fn(&idx.Points[mid]) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return &idx.Points[lo-1]
+}
+
+func (f *File) readFromPoint(idx *GzipIndex, point *GzipAccessPoint) (io.Reader, error) {
+	if err := idx.check(f); err != nil {
+		return nil, err
+	}
+	in := newInflater(io.NewSectionReader(f.handle, point.In, idx.CompressedSize-point.In))
+	if _, err := in.bits(point.Bits); err != nil {
+		return nil, err
+	}
+	in.n = copy(in.out, point.Window)
+	in.done = in.n
+	in.rpos = in.n
+	in.total = point.Out
+	in.lines = point.Line
+	// We're in the middle of a member. Its checksum can't be verified.
+	in.state = inflateBlock
+	in.members = 1
+	in.partial = true
+	return in, nil
+}
+
+const (
+	inflateHeader = iota
+	inflateBlock
+	inflateStored
+	inflateCodes
+	inflateTrailer
+)
+
+// Longest output of a single deflate symbol
+const maxMatch = 258
+
+// inflater is an io.Reader that decompresses a stream of gzip members.
+// Unlike compress/gzip, it can start at any deflate block and lets the
+// caller find out where each block and member begins.
+type inflater struct {
+	r *bufio.Reader
+	// Number of bytes read from r
+	read int64
+	// Bit buffer
+	bitbuf uint32
+	nbits  uint
+	// Decompressed data. The gzWindowSize bytes preceding rpos are history.
+	// Bytes past done have not yet been counted into total, lines and crc.
+	// Bytes past rpos have not yet been returned by Read.
+	out   []byte
+	n     int
+	done  int
+	rpos  int
+	total int64
+	lines int64
+	crc   uint32
+
+	state   int
+	final   bool
+	stored  int
+	litlen  *huffman
+	dist    *huffman
+	dynamic [2]huffman
+	// Uncompressed offset at the start of the current member
+	memberStart int64
+	members     int
+	// Set when starting in the middle of a member
+	partial bool
+	err     error
+
+	// Called at the start of every deflate block
+	onBlock func()
+	// Called at the end of every member
+	onMember func()
+}
+
+func newInflater(r io.Reader) *inflater {
+	return &inflater{
+		r:   bufio.NewReaderSize(r, 256*1024),
+		out: make([]byte, 4*gzWindowSize),
+	}
+}
+
+func (in *inflater) Read(b []byte) (int, error) {
+	for in.rpos == in.n {
+		if in.err != nil {
+			return 0, in.err
+		}
+		in.err = in.step()
+	}
+	n := copy(b, in.out[in.rpos:in.n])
+	in.rpos += n
+	return n, nil
+}
+
+// bitPos returns the number of bits consumed
+func (in *inflater) bitPos() int64 {
+	return in.read*8 - int64(in.nbits)
+}
+
+// need makes sure that the bit buffer holds at least n bits
+func (in *inflater) need(n uint) error {
+	for in.nbits < n {
+		b, err := in.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		in.read++
+		in.bitbuf |= uint32(b) << in.nbits
+		in.nbits += 8
+	}
+	return nil
+}
+
+func (in *inflater) bits(n uint) (uint32, error) {
+	if err := in.need(n); err != nil {
+		return 0, err
+	}
+	v := in.bitbuf & (1<<n - 1)
+	in.bitbuf >>= n
+	in.nbits -= n
+	return v, nil
+}
+
+// bytes reads a little-endian value of nbytes bytes
+func (in *inflater) bytes(nbytes int) (uint32, error) {
+	var ret uint32
+	for i := 0; i < nbytes; i++ {
+		b, err := in.bits(8)
+		if err != nil {
+			return 0, err
+		}
+		ret |= b << (8 * uint(i))
+	}
+	return ret, nil
+}
+
+// align drops bits up to the next byte boundary
+func (in *inflater) align() {
+	in.bitbuf >>= in.nbits % 8
+	in.nbits -= in.nbits % 8
+}
+
+// count accounts for the pending output
+func (in *inflater) count() {
+	pending := in.out[in.done:in.n]
+	in.total += int64(len(pending))
+	in.lines += int64(bytes.Count(pending, []byte{'\n'}))
+	if !in.partial {
+		in.crc = crc32.Update(in.crc, crc32.IEEETable, pending)
+	}
+	in.done = in.n
+}
+
+// window returns a copy of the last gzWindowSize bytes of output
+func (in *inflater) window() []byte {
+	in.count()
+	start := in.n - gzWindowSize
+	if start < 0 {
+		start = 0
+	}
+	return append([]byte(nil), in.out[start:in.n]...)
+}
+
+// step decodes some more data. It is only called once all the output
+// has been read.
+func (in *inflater) step() (err error) {
+	if len(in.out)-in.n < maxMatch {
+		// Make room, keeping the history
+		in.count()
+		in.n = copy(in.out, in.out[in.n-gzWindowSize:in.n])
+		in.done = in.n
+		in.rpos = in.n
+	}
+
+	switch in.state {
+	case inflateHeader:
+		if in.members > 0 {
+			// Are there more members?
+			if _, err = in.r.Peek(1); err == io.EOF && in.nbits == 0 {
+				return io.EOF
+			}
+		}
+		if err = in.header(); err != nil {
+			return
+		}
+		in.count()
+		in.crc = 0
+		in.memberStart = in.total
+		in.state = inflateBlock
+	case inflateBlock:
+		if in.onBlock != nil {
+			in.onBlock()
+		}
+		err = in.block()
+	case inflateStored:
+		for ; in.stored > 0 && in.n < len(in.out); in.stored-- {
+			var b uint32
+			if b, err = in.bits(8); err != nil {
+				return
+			}
+			in.out[in.n] = byte(b)
+			in.n++
+		}
+		if in.stored == 0 {
+			in.endBlock()
+		}
+	case inflateCodes:
+		err = in.codes()
+	case inflateTrailer:
+		err = in.trailer()
+	}
+	return
+}
+
+func (in *inflater) header() error {
+	var v uint32
+	var err error
+
+	skipString := func() error {
+		for {
+			b, err := in.bits(8)
+			if err != nil || b == 0 {
+				return err
+			}
+		}
+	}
+
+	if v, err = in.bytes(3); err != nil {
+		return err
+	}
+	if v != 0x088b1f {
+		return gzip.ErrHeader
+	}
+	flags, err := in.bytes(1)
+	if err != nil {
+		return err
+	}
+	// MTIME, XFL and OS
+	if _, err = in.bytes(6); err != nil {
+		return err
+	}
+	if flags&0x04 != 0 {
+		// FEXTRA
+		xlen, err := in.bytes(2)
+		if err != nil {
+			return err
+		}
+		for i := uint32(0); i < xlen; i++ {
+			if _, err = in.bytes(1); err != nil {
+				return err
+			}
+		}
+	}
+	if flags&0x08 != 0 {
+		// FNAME
+		if err = skipString(); err != nil {
+			return err
+		}
+	}
+	if flags&0x10 != 0 {
+		// FCOMMENT
+		if err = skipString(); err != nil {
+			return err
+		}
+	}
+	if flags&0x02 != 0 {
+		// FHCRC
+		if _, err = in.bytes(2); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (in *inflater) trailer() error {
+	var v uint32
+	var err error
+
+	in.align()
+	in.count()
+	if v, err = in.bytes(4); err != nil {
+		return err
+	}
+	if !in.partial && v != in.crc {
+		return gzip.ErrChecksum
+	}
+	if v, err = in.bytes(4); err != nil {
+		return err
+	}
+	if !in.partial && v != uint32(in.total-in.memberStart) {
+		return gzip.ErrChecksum
+	}
+	in.partial = false
+	in.members++
+	in.state = inflateHeader
+	if in.onMember != nil {
+		in.onMember()
+	}
+	return nil
+}
+
+// block reads the header of a deflate block
+func (in *inflater) block() error {
+	header, err := in.bits(3)
+	if err != nil {
+		return err
+	}
+	in.final = header&1 == 1
+	switch header >> 1 {
+	case 0:
+		in.align()
+		length, err := in.bits(16)
+		if err != nil {
+			return err
+		}
+		nlength, err := in.bits(16)
+		if err != nil {
+			return err
+		}
+		if length != ^nlength&0xffff {
+			return errors.New("Invalid stored block length")
+		}
+		in.stored = int(length)
+		in.state = inflateStored
+		if in.stored == 0 {
+			in.endBlock()
+		}
+	case 1:
+		in.litlen = &fixedLitLen
+		in.dist = &fixedDist
+		in.state = inflateCodes
+	case 2:
+		if err = in.tables(); err != nil {
+			return err
+		}
+		in.litlen = &in.dynamic[0]
+		in.dist = &in.dynamic[1]
+		in.state = inflateCodes
+	default:
+		return errors.New("Invalid deflate block type")
+	}
+	return nil
+}
+
+func (in *inflater) endBlock() {
+	if in.final {
+		in.state = inflateTrailer
+	} else {
+		in.state = inflateBlock
+	}
+}
+
+var (
+	lengthBase  = []uint16{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = []uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase    = []uint16{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra   = []uint8{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+	// Order in which code length code lengths are sent
+	codeLengthOrder = []int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+	fixedLitLen, fixedDist huffman
+)
+
+func init() {
+	lengths := make([]uint8, 288)
+	for i := range lengths {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		default:
+			lengths[i] = 8
+		}
+	}
+	if err := fixedLitLen.init(lengths); err != nil {
+		panic(err)
+	}
+	lengths = make([]uint8, 30)
+	for i := range lengths {
+		lengths[i] = 5
+	}
+	if err := fixedDist.init(lengths); err != nil {
+		panic(err)
+	}
+}
+
+// huffman is a canonical huffman code that is decoded with a single
+// lookup into a table indexed by the next maxlen bits
+type huffman struct {
+	maxlen uint
+	// symbol<<4 | length. A length of 0 marks an invalid code.
+	table []uint16
+}
+
+func (h *huffman) init(lengths []uint8) error {
+	var count [16]int
+	h.maxlen = 0
+	for _, l := range lengths {
+		count[l]++
+		if uint(l) > h.maxlen {
+			h.maxlen = uint(l)
+		}
+	}
+	count[0] = 0
+
+	// Assign the first code of each length and check for over-subscription
+	var next [16]int
+	code, left := 0, 1
+	for l := 1; l < 16; l++ {
+		left <<= 1
+		if left -= count[l]; left < 0 {
+			return errors.New("Over-subscribed huffman code")
+		}
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+
+	size := 1 << h.maxlen
+	if cap(h.table) >= size {
+		h.table = h.table[:size]
+		for i := range h.table {
+			h.table[i] = 0
+		}
+	} else {
+		h.table = make([]uint16, size)
+	}
+	for sym, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		code := next[l]
+		next[l]++
+		// Codes are packed starting with their most significant bit
+		reversed := 0
+		for i := uint8(0); i < l; i++ {
+			reversed = reversed<<1 | (code>>i)&1
+		}
+		for i := reversed; i < size; i += 1 << l {
+			h.table[i] = uint16(sym)<<4 | uint16(l)
+		}
+	}
+	return nil
+}
+
+func (in *inflater) decode(h *huffman) (int, error) {
+	if err := in.need(h.maxlen); err != nil {
+		// There may be fewer bits left than the longest code.
+		// Make do with what we have.
+		if err != io.ErrUnexpectedEOF || in.nbits == 0 {
+			return 0, err
+		}
+	}
+	entry := h.table[in.bitbuf&(1<<h.maxlen-1)]
+	l := uint(entry & 15)
+	if l == 0 {
+		return 0, errors.New("Invalid huffman code")
+	}
+	if l > in.nbits {
+		return 0, io.ErrUnexpectedEOF
+	}
+	in.bitbuf >>= l
+	in.nbits -= l
+	return int(entry >> 4), nil
+}
+
+// codes decodes the symbols of a huffman block until either the block
+// ends or there is no room left for the output
+func (in *inflater) codes() error {
+	for len(in.out)-in.n >= maxMatch {
+		sym, err := in.decode(in.litlen)
+		if err != nil {
+			return err
+		}
+		if sym < 256 {
+			in.out[in.n] = byte(sym)
+			in.n++
+			continue
+		}
+		if sym == 256 {
+			in.endBlock()
+			return nil
+		}
+
+		sym -= 257
+		if sym >= len(lengthBase) {
+			return errors.New("Invalid length symbol")
+		}
+		extra, err := in.bits(uint(lengthExtra[sym]))
+		if err != nil {
+			return err
+		}
+		length := int(lengthBase[sym]) + int(extra)
+
+		if sym, err = in.decode(in.dist); err != nil {
+			return err
+		}
+		if sym >= len(distBase) {
+			return errors.New("Invalid distance symbol")
+		}
+		if extra, err = in.bits(uint(distExtra[sym])); err != nil {
+			return err
+		}
+		distance := int(distBase[sym]) + int(extra)
+		if distance > in.n {
+			return errors.New("Distance is too far back")
+		}
+		// The source may overlap with the destination
+		for ; length > 0; length-- {
+			in.out[in.n] = in.out[in.n-distance]
+			in.n++
+		}
+	}
+	return nil
+}
+
+// tables reads the huffman codes of a dynamic block
+func (in *inflater) tables() error {
+	var codelen huffman
+
+	v, err := in.bits(14)
+	if err != nil {
+		return err
+	}
+	nlen := int(v&0x1f) + 257
+	ndist := int(v>>5&0x1f) + 1
+	ncode := int(v>>10) + 4
+	if nlen > 286 || ndist > 30 {
+		return errors.New("Invalid dynamic block header")
+	}
+
+	lengths := make([]uint8, 19)
+	for i := 0; i < ncode; i++ {
+		if v, err = in.bits(3); err != nil {
+			return err
+		}
+		lengths[codeLengthOrder[i]] = uint8(v)
+	}
+	if err = codelen.init(lengths); err != nil {
+		return err
+	}
+
+	lengths = make([]uint8, nlen+ndist)
+	for i := 0; i < len(lengths); {
+		sym, err := in.decode(&codelen)
+		if err != nil {
+			return err
+		}
+		if sym < 16 {
+			lengths[i] = uint8(sym)
+			i++
+			continue
+		}
+		var repeat uint32
+		var value uint8
+		switch sym {
+		case 16:
+			if i == 0 {
+				return errors.New("Repeat with no previous length")
+			}
+			value = lengths[i-1]
+			repeat, err = in.bits(2)
+			repeat += 3
+		case 17:
+			repeat, err = in.bits(3)
+			repeat += 3
+		default:
+			repeat, err = in.bits(7)
+			repeat += 11
+		}
+		if err != nil {
+			return err
+		}
+		if i+int(repeat) > len(lengths) {
+			return errors.New("Too many code lengths")
+		}
+		for ; repeat > 0; repeat-- {
+			lengths[i] = value
+			i++
+		}
+	}
+	if lengths[256] == 0 {
+		return errors.New("Missing end-of-block code")
+	}
+	if err = in.dynamic[0].init(lengths[:nlen]); err != nil {
+		return err
+	}
+	return in.dynamic[1].init(lengths[nlen:])
+}
@@ -0,0 +1,278 @@
+package gocommons
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gzipTestData returns lines of text that compress reasonably but not
+// trivially so that the gzip file has plenty of dynamic blocks
+func gzipTestData(nlines int) []byte {
+	r := mathrand.New(mathrand.NewSource(42))
+	words := []string{"alpha", "beta", "gamma", "delta", "epsilon", "zeta", "eta", "theta"}
+	buf := new(bytes.Buffer)
+	for i := 0; i < nlines; i++ {
+		fmt.Fprintf(buf, "%08d", i)
+		for j := r.Intn(20); j >= 0; j-- {
+			fmt.Fprintf(buf, " %v-%d", words[r.Intn(len(words))], r.Intn(1000))
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+func writeGzipTestFile(t *testing.T, path string, members ...[]byte) {
+	require := require.New(t)
+
+	f, err := os.Create(path)
+	require.Nil(err)
+	defer f.Close()
+	for idx, data := range members {
+		// Mix levels to get stored, fixed and dynamic blocks
+		level := []int{gzip.DefaultCompression, gzip.NoCompression, gzip.HuffmanOnly, gzip.BestSpeed}[idx%4]
+		writer, err := gzip.NewWriterLevel(f, level)
+		require.Nil(err)
+		writer.Name = "member"
+		// Write in pieces so that there are flushes in between
+		for len(data) > 0 {
+			n := 100000
+			if n > len(data) {
+				n = len(data)
+			}
+			_, err = writer.Write(data[:n])
+			require.Nil(err)
+			require.Nil(writer.Flush())
+			data = data[n:]
+		}
+		require.Nil(writer.Close())
+	}
+}
+
+func checkGzipIndex(t *testing.T, path string, expected []byte) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	f, err := Open(path, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	defer f.Close()
+
+	idx, err := BuildGzipIndex(f, 64*1024)
+	require.Nil(err, "Failed to build index", err)
+	assert.Equal(int64(len(expected)), idx.Size)
+	assert.Equal(int64(bytes.Count(expected, []byte("\n"))), idx.Lines)
+	assert.True(len(idx.Points) > 1, "Expected multiple access points")
+
+	r := mathrand.New(mathrand.NewSource(7))
+	offsets := []int64{0, int64(len(expected)), int64(len(expected)) - 1}
+	for i := 0; i < 50; i++ {
+		offsets = append(offsets, r.Int63n(int64(len(expected))))
+	}
+	for _, point := range idx.Points {
+		offsets = append(offsets, point.Out)
+	}
+	for _, offset := range offsets {
+		reader, err := f.SeekGzip(idx, offset)
+		require.Nil(err, "Failed to seek to %d", offset)
+		got := make([]byte, 1000)
+		n, _ := io.ReadFull(reader, got)
+		end := offset + 1000
+		if end > int64(len(expected)) {
+			end = int64(len(expected))
+		}
+		assert.Equal(expected[offset:end], got[:n], fmt.Sprintf("Mismatch at offset %d", offset))
+	}
+
+	// Read everything from the middle
+	reader, err := f.SeekGzip(idx, idx.Size/2)
+	require.Nil(err)
+	got, err := io.ReadAll(reader)
+	assert.Nil(err)
+	assert.True(bytes.Equal(expected[idx.Size/2:], got), "Failed to read to the end")
+
+	lines := strings.SplitAfter(string(expected), "\n")
+	for _, line := range []int64{0, 1, idx.Lines / 3, idx.Lines / 2, idx.Lines - 1} {
+		reader, err := f.SeekGzipLine(idx, line)
+		require.Nil(err, "Failed to seek to line %d", line)
+		got, err := bufio.NewReader(reader).ReadString('\n')
+		assert.Nil(err)
+		assert.Equal(lines[line], got, fmt.Sprintf("Mismatch at line %d", line))
+	}
+
+	_, err = f.SeekGzip(idx, idx.Size+1)
+	assert.NotNil(err, "Should have failed to seek past the end")
+	_, err = f.SeekGzipLine(idx, idx.Lines+1)
+	assert.NotNil(err, "Should have failed to seek past the last line")
+}
+
+func TestGzipIndex(t *testing.T) {
+	t.Parallel()
+
+	data := gzipTestData(50000)
+	path := "/tmp/gzindex-single.gz"
+	writeGzipTestFile(t, path, data)
+	defer os.Remove(path)
+	checkGzipIndex(t, path, data)
+}
+
+func TestGzipIndexMultiMember(t *testing.T) {
+	t.Parallel()
+
+	data := gzipTestData(40000)
+	parts := [][]byte{data[:len(data)/5], data[len(data)/5 : len(data)/2], data[len(data)/2 : len(data)*3/4], data[len(data)*3/4:]}
+	path := "/tmp/gzindex-multi.gz"
+	writeGzipTestFile(t, path, parts...)
+	defer os.Remove(path)
+	checkGzipIndex(t, path, data)
+}
+
+func TestGzipIndexParallelWriter(t *testing.T) {
+	t.Parallel()
+
+	require := require.New(t)
+
+	data := gzipTestData(30000)
+	path := "/tmp/gzindex-parallel.gz"
+	f, err := Open(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, GZ_TRUE)
+	require.Nil(err)
+	defer os.Remove(path)
+	writer, err := f.ParallelWriter(0, 64*1024, 4)
+	require.Nil(err)
+	writer.Write(data)
+	require.Nil(writer.Close())
+	f.Close()
+
+	checkGzipIndex(t, path, data)
+}
+
+func TestGzipIndexSidecar(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data := gzipTestData(20000)
+	path := "/tmp/gzindex-sidecar.gz"
+	writeGzipTestFile(t, path, data)
+	defer os.Remove(path)
+	defer os.Remove(GzipIndexPath(path))
+
+	f, err := Open(path, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	defer f.Close()
+
+	built, err := LoadOrBuildGzipIndex(f, 32*1024)
+	require.Nil(err)
+	exists, _ := Exists(GzipIndexPath(path))
+	assert.True(exists, "Sidecar was not saved")
+
+	loaded, err := LoadGzipIndex(GzipIndexPath(path))
+	require.Nil(err)
+	assert.Equal(built, loaded, "Loaded index does not match")
+
+	reader, err := f.SeekGzipLine(loaded, 12345)
+	require.Nil(err)
+	got, _ := bufio.NewReader(reader).ReadString('\n')
+	assert.True(strings.HasPrefix(got, "00012345"), got)
+
+	// A stale index should not be used
+	writeGzipTestFile(t, path, data[:len(data)/2])
+	f2, err := Open(path, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	defer f2.Close()
+	_, err = f2.SeekGzip(loaded, 0)
+	assert.NotNil(err, "Should have failed to use stale index")
+
+	rebuilt, err := LoadOrBuildGzipIndex(f2, 32*1024)
+	require.Nil(err)
+	assert.Equal(int64(len(data)/2), rebuilt.Size)
+}
+
+func TestGzipIndexSameSize(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Stored blocks keep the compressed size the same for the same length
+	writeStored := func(path string, data []byte) {
+		var buf bytes.Buffer
+		writer, err := gzip.NewWriterLevel(&buf, gzip.NoCompression)
+		require.Nil(err)
+		writer.Write(data)
+		require.Nil(writer.Close())
+		require.Nil(os.WriteFile(path, buf.Bytes(), 0664))
+	}
+
+	data := gzipTestData(2000)
+	path := "/tmp/gzindex-samesize.gz"
+	writeStored(path, data)
+	defer os.Remove(path)
+	defer os.Remove(GzipIndexPath(path))
+	stat, err := os.Stat(path)
+	require.Nil(err)
+
+	f, err := Open(path, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	idx, err := LoadOrBuildGzipIndex(f, 0)
+	require.Nil(err)
+	f.Close()
+
+	changed := append([]byte(nil), data...)
+	changed[0] = 'X'
+	writeStored(path, changed)
+	// Even with the same size and modification time
+	require.Nil(os.Chtimes(path, stat.ModTime(), stat.ModTime()))
+
+	f, err = Open(path, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	defer f.Close()
+	_, err = f.SeekGzip(idx, 0)
+	assert.NotNil(err, "Should have failed to use stale index")
+
+	rebuilt, err := LoadOrBuildGzipIndex(f, 0)
+	require.Nil(err)
+	reader, err := f.SeekGzip(rebuilt, 0)
+	require.Nil(err)
+	got := make([]byte, 1)
+	_, err = io.ReadFull(reader, got)
+	require.Nil(err)
+	assert.Equal("X", string(got))
+}
+
+func TestGzipIndexErrors(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Not gzip
+	f, err := Open("test_files/open-test.txt", os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	_, err = BuildGzipIndex(f, 0)
+	assert.NotNil(err, "Should have failed to index plain file")
+	f.Close()
+
+	// Truncated
+	data := gzipTestData(1000)
+	path := "/tmp/gzindex-truncated.gz"
+	writeGzipTestFile(t, path, data)
+	defer os.Remove(path)
+	stat, _ := os.Stat(path)
+	os.Truncate(path, stat.Size()/2)
+
+	f, err = Open(path, os.O_RDONLY, GZ_TRUE)
+	require.Nil(err)
+	defer f.Close()
+	_, err = BuildGzipIndex(f, 0)
+	assert.NotNil(err, "Should have failed to index truncated file")
+}
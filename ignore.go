@@ -0,0 +1,123 @@
+package gocommons
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/bmatcuk/doublestar"
+)
+
+// ignoreRule is a single line of an ignore file
+type ignoreRule struct {
+	pattern string
+	// Re-include paths excluded by an earlier rule
+	negate bool
+	// Only match directories
+	dirOnly bool
+	// Match the path relative to the ignore file rather than the name
+	anchored bool
+}
+
+// ignoreRules are the rules of one ignore file. They apply to the
+// directory the file is in and everything below it.
+type ignoreRules struct {
+	// Directory of the ignore file relative to the root of the walk
+	base  string
+	rules []ignoreRule
+}
+
+// parseIgnore parses gitignore syntax.
+// Blank lines and lines starting with # are skipped. A leading ! negates
+// the rule, a trailing / only matches directories and a / anywhere else
+// anchors the pattern to the directory of the ignore file. Patterns
+// without a / match names at any depth. A backslash escapes the next
+// character, including leading # and ! and trailing spaces.
+func parseIgnore(name string, data []byte) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		if err := checkPattern(line); err != nil {
+			return nil, errors.New(fmt.Sprintf("%v:%d: %v", name, lineno, err))
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// readIgnore reads the ignore file in dir. It returns nil if there is none.
+func readIgnore(fsys FS, dir string, rel string, name string) (*ignoreRules, error) {
+	file := path.Join(dir, name)
+	data, err := fs.ReadFile(fsys, file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	rules, err := parseIgnore(file, data)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	return &ignoreRules{base: rel, rules: rules}, nil
+}
+
+// isIgnored reports whether rel, a path relative to the root of the walk,
+// is ignored by chain. Rules further down the chain and later in a file
+// take precedence.
+func isIgnored(chain []*ignoreRules, rel string, isDir bool) bool {
+	ignored := false
+	name := path.Base(rel)
+	for _, rules := range chain {
+		local := rel
+		if rules.base != "" {
+			if !strings.HasPrefix(rel, rules.base+"/") {
+				continue
+			}
+			local = rel[len(rules.base)+1:]
+		}
+		for _, rule := range rules.rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			target := name
+			if rule.anchored {
+				target = local
+			}
+			if m, _ := doublestar.Match(rule.pattern, target); m {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,90 @@
+package gocommons
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIgnore(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data := "# comment\n\n*.tmp  \n!keep.tmp\nbuild/\n/top.txt\ndocs/*.pdf\n\\#hash\ntrailing\\ \n"
+	rules, err := parseIgnore(".ignore", []byte(data))
+	require.Nil(err)
+	assert.Equal([]ignoreRule{
+		{pattern: "*.tmp"},
+		{pattern: "keep.tmp", negate: true},
+		{pattern: "build", dirOnly: true},
+		{pattern: "top.txt", anchored: true},
+		{pattern: "docs/*.pdf", anchored: true},
+		{pattern: "\\#hash"},
+		{pattern: "trailing\\ "},
+	}, rules)
+
+	_, err = parseIgnore(".ignore", []byte("ok\n[\n"))
+	assert.NotNil(err, "Should have failed on bad pattern")
+}
+
+func TestIsIgnored(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	root, err := parseIgnore(".ignore", []byte("*.tmp\n!keep.tmp\nbuild/\n/top.txt\n**/cache/**\n"))
+	require.Nil(err)
+	sub, err := parseIgnore("a/.ignore", []byte("!b.tmp\nx/*.txt\n"))
+	require.Nil(err)
+	chain := []*ignoreRules{{base: "", rules: root}, {base: "a", rules: sub}}
+
+	assert.True(isIgnored(chain, "x.tmp", false))
+	assert.True(isIgnored(chain, "d/x.tmp", false))
+	assert.False(isIgnored(chain, "d/keep.tmp", false))
+	// Deeper files override shallower ones
+	assert.False(isIgnored(chain, "a/b.tmp", false))
+	assert.True(isIgnored(chain, "b.tmp", false))
+	// Directory only
+	assert.True(isIgnored(chain, "d/build", true))
+	assert.False(isIgnored(chain, "d/build", false))
+	// Anchored
+	assert.True(isIgnored(chain, "top.txt", false))
+	assert.False(isIgnored(chain, "d/top.txt", false))
+	assert.True(isIgnored(chain, "a/x/y.txt", false))
+	assert.False(isIgnored(chain, "x/y.txt", false))
+	assert.True(isIgnored(chain, "d/cache/z", false))
+}
+
+func TestListFilesIgnoring(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mfs := newTestMemFS(t)
+	require.Nil(mfs.MkdirAll("/data/scratch", 0775))
+	require.Nil(mfs.WriteFile("/data/scratch/s.txt", []byte("s\n"), 0664))
+	require.Nil(mfs.WriteFile("/data/.ignore", []byte("scratch/\n*.gz\n"), 0664))
+	require.Nil(mfs.WriteFile("/data/1/.ignore", []byte("/b.txt\n"), 0664))
+	require.Nil(mfs.WriteFile("/data/2/b.txt", []byte("b\n"), 0664))
+
+	files, err := ListFilesFS(mfs, "/data", []string{"*.txt"})
+	require.Nil(err)
+	assert.Equal([]string{"/data/1/b.txt", "/data/2/b.txt", "/data/a.txt", "/data/scratch/s.txt"}, files)
+
+	files, err = ListFilesIgnoringFS(mfs, "/data", []string{"*.txt", "*.gz"}, ".ignore")
+	require.Nil(err)
+	assert.Equal([]string{"/data/2/b.txt", "/data/a.txt"}, files)
+
+	dirs, err := ListDirsIgnoringFS(mfs, "/data", []string{"*/"}, ".ignore")
+	require.Nil(err)
+	assert.Equal([]string{"/data/1", "/data/2", "/data/3"}, dirs)
+
+	require.Nil(mfs.WriteFile("/data/3/.ignore", []byte("[\n"), 0664))
+	_, err = ListFS(mfs, "/data", ListOptions{IgnoreFile: ".ignore"})
+	assert.NotNil(err, "Should have failed on bad ignore file")
+}
@@ -0,0 +1,342 @@
+package gocommons
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bmatcuk/doublestar"
+)
+
+// ListType selects what List returns
+type ListType int
+
+const (
+	LIST_FILES ListType = 1 << iota
+	LIST_DIRS
+	LIST_ALL = LIST_FILES | LIST_DIRS
+)
+
+var ErrSymlinkLoop = errors.New("Symlink loop")
+
+// ListOptions configure List. The zero value lists every file that is
+// not hidden.
+type ListOptions struct {
+	// Files, directories or both. 0 means LIST_FILES
+	Type ListType
+	// doublestar patterns matched against the slash separated path
+	// relative to the root, e.g. "*.txt" for files directly under the
+	// root and "**/*.txt" for files anywhere. A trailing slash is ignored.
+	// No patterns means everything.
+	Include []string
+	// Like Include. Excluded directories are not descended into
+	Exclude []string
+	// Entries directly under the root are at depth 1. Entries above
+	// MinDepth are not returned. Directories at MaxDepth are not
+	// descended into. 0 means no limit.
+	MinDepth int
+	MaxDepth int
+	// Descend into symlinked directories. A symlink that leads back to
+	// one of its own parent directories fails with ErrSymlinkLoop.
+	// Without it, symlinks are listed like files.
+	FollowSymlinks bool
+	// Include entries whose name starts with a dot. Otherwise hidden
+	// directories are not descended into.
+	Hidden bool
+	// Called with errors that occur while listing. Returning nil skips
+	// the offending entry and carries on. If it is nil, List stops at the
+	// first error and returns it. Calls are never concurrent.
+	OnError func(path string, err error) error
+	// Name of the ignore files to honour, e.g. ".gitignore". Their rules
+	// use gitignore syntax and apply to the directory they are in and
+	// everything below it. Ignored directories are not descended into.
+	IgnoreFile string
+	// Number of directories read concurrently by Walk.
+	// 0 means runtime.NumCPU()
+	Workers int
+}
+
+// List returns the sorted paths under root selected by opts.
+// Unlike ListFiles, patterns are matched against the path relative to
+// root and errors are returned rather than printed.
+func List(root string, opts ListOptions) ([]string, error) {
+	return ListFS(OS, root, opts)
+}
+
+// ListFS is like List but lists paths in fsys
+func ListFS(fsys FS, root string, opts ListOptions) (matches []string, err error) {
+	channel := make(chan string, 1024)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err = WalkFS(context.Background(), fsys, root, opts, channel)
+	}()
+	for path := range channel {
+		matches = append(matches, path)
+	}
+	<-done
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// Walk sends the paths under root selected by opts to channel as they
+// are found, reading up to opts.Workers directories at a time. Paths
+// arrive in no particular order. The channel is closed when Walk returns.
+// Walk stops early when ctx is done and returns its error.
+func Walk(ctx context.Context, root string, opts ListOptions, channel chan<- string) error {
+	return WalkFS(ctx, OS, root, opts, channel)
+}
+
+// WalkFS is like Walk but walks fsys
+func WalkFS(ctx context.Context, fsys FS, root string, opts ListOptions, channel chan<- string) error {
+	defer close(channel)
+
+	if opts.Type == 0 {
+		opts.Type = LIST_FILES
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	for _, pattern := range append(append([]string(nil), opts.Include...), opts.Exclude...) {
+		if err := checkPattern(pattern); err != nil {
+			return err
+		}
+	}
+	info, err := fsys.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: "list", Path: root, Err: errors.New("not a directory")}
+	}
+
+	w := &walker{ctx: ctx, fsys: fsys, opts: opts, channel: channel}
+	w.cond = sync.NewCond(&w.mutex)
+	w.push(walkDir{path: root, depth: 1, ancestors: []fs.FileInfo{info}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work()
+		}()
+	}
+	wg.Wait()
+	if w.err == nil {
+		// Workers may have drained the queue just as ctx got cancelled
+		w.err = ctx.Err()
+	}
+	return w.err
+}
+
+// checkPattern fails if pattern is not a valid doublestar pattern
+func checkPattern(pattern string) error {
+	// doublestar stops parsing at the first mismatch
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return errors.New(fmt.Sprintf("Bad pattern '%v': %v", pattern, err))
+		}
+	}
+	return nil
+}
+
+// walkDir is a directory waiting to be read
+type walkDir struct {
+	path string
+	rel  string
+	// Depth of the entries in this directory
+	depth int
+	// Directories from the root down to and including this one
+	ancestors []fs.FileInfo
+	// Ignore files of the ancestors, root first
+	ignores []*ignoreRules
+}
+
+type walker struct {
+	ctx     context.Context
+	fsys    FS
+	opts    ListOptions
+	channel chan<- string
+
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	queue   []walkDir
+	pending int // Directories queued or being read
+	err     error
+
+	errmu sync.Mutex // Serializes OnError
+}
+
+func (w *walker) push(dir walkDir) {
+	w.mutex.Lock()
+	w.queue = append(w.queue, dir)
+	w.pending++
+	w.mutex.Unlock()
+	w.cond.Signal()
+}
+
+// next blocks until there is a directory to read. It returns false once
+// every directory has been read or the walk has failed.
+func (w *walker) next() (dir walkDir, ok bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for len(w.queue) == 0 && w.pending > 0 && w.err == nil {
+		w.cond.Wait()
+	}
+	if len(w.queue) == 0 || w.err != nil {
+		return
+	}
+	// Depth first keeps the queue short
+	dir = w.queue[len(w.queue)-1]
+	w.queue = w.queue[:len(w.queue)-1]
+	return dir, true
+}
+
+func (w *walker) done(err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.pending--
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	if w.pending == 0 || err != nil {
+		w.cond.Broadcast()
+	}
+}
+
+func (w *walker) work() {
+	for {
+		dir, ok := w.next()
+		if !ok {
+			return
+		}
+		err := w.ctx.Err()
+		if err == nil {
+			err = w.read(dir)
+		}
+		w.done(err)
+	}
+}
+
+func (w *walker) fail(path string, err error) error {
+	if w.opts.OnError == nil {
+		return err
+	}
+	w.errmu.Lock()
+	defer w.errmu.Unlock()
+	return w.opts.OnError(path, err)
+}
+
+func (w *walker) send(path string) error {
+	select {
+	case w.channel <- path:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+// matchAny matches rel against patterns as described in ListOptions
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if m, _ := doublestar.Match(strings.TrimSuffix(pattern, "/"), rel); m {
+			return true
+		}
+	}
+	return false
+}
+
+func isLoop(ancestors []fs.FileInfo, info fs.FileInfo) bool {
+	for _, ancestor := range ancestors {
+		if os.SameFile(ancestor, info) {
+			return true
+		}
+	}
+	return false
+}
+
+// read sends the selected entries of dir and queues its subdirectories
+func (w *walker) read(dir walkDir) error {
+	entries, err := w.fsys.ReadDir(dir.path)
+	if err != nil {
+		return w.fail(dir.path, err)
+	}
+	ignores := dir.ignores
+	if w.opts.IgnoreFile != "" {
+		rules, err := readIgnore(w.fsys, dir.path, dir.rel, w.opts.IgnoreFile)
+		if err != nil {
+			if err = w.fail(path.Join(dir.path, w.opts.IgnoreFile), err); err != nil {
+				return err
+			}
+		} else if rules != nil {
+			ignores = append(ignores[:len(ignores):len(ignores)], rules)
+		}
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !w.opts.Hidden && strings.HasPrefix(name, ".") {
+			continue
+		}
+		p := path.Join(dir.path, name)
+		r := path.Join(dir.rel, name)
+		if matchAny(w.opts.Exclude, r) {
+			continue
+		}
+
+		isDir := entry.IsDir()
+		var info fs.FileInfo
+		if entry.Type()&fs.ModeSymlink != 0 && w.opts.FollowSymlinks {
+			// Dangling symlinks are listed like files
+			if info, err = w.fsys.Stat(p); err == nil && info.IsDir() {
+				isDir = true
+			}
+		}
+		if isIgnored(ignores, r, isDir) {
+			continue
+		}
+		if isDir && info != nil && isLoop(dir.ancestors, info) {
+			if err = w.fail(p, &fs.PathError{Op: "list", Path: p, Err: ErrSymlinkLoop}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		selected := dir.depth >= w.opts.MinDepth && (len(w.opts.Include) == 0 || matchAny(w.opts.Include, r))
+		if isDir {
+			if selected && w.opts.Type&LIST_DIRS != 0 {
+				if err = w.send(p); err != nil {
+					return err
+				}
+			}
+			if w.opts.MaxDepth > 0 && dir.depth >= w.opts.MaxDepth {
+				continue
+			}
+			if info == nil {
+				if info, err = entry.Info(); err != nil {
+					if err = w.fail(p, err); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			ancestors := append(dir.ancestors[:len(dir.ancestors):len(dir.ancestors)], info)
+			w.push(walkDir{path: p, rel: r, depth: dir.depth + 1, ancestors: ancestors, ignores: ignores})
+		} else if selected && w.opts.Type&LIST_FILES != 0 {
+			if err = w.send(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package gocommons
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListFS(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mfs := newTestMemFS(t)
+	require.Nil(mfs.WriteFile("/data/.hidden.txt", []byte("h\n"), 0664))
+	require.Nil(mfs.MkdirAll("/data/.git", 0775))
+	require.Nil(mfs.WriteFile("/data/.git/d.txt", []byte("d\n"), 0664))
+
+	files, err := ListFS(mfs, "/data", ListOptions{})
+	require.Nil(err)
+	assert.Equal([]string{"/data/1/11/c.gz", "/data/1/b.txt", "/data/a.txt"}, files)
+
+	// Patterns are relative to the root
+	files, err = ListFS(mfs, "/data", ListOptions{Include: []string{"*.txt"}})
+	require.Nil(err)
+	assert.Equal([]string{"/data/a.txt"}, files)
+	files, err = ListFS(mfs, "/data", ListOptions{Include: []string{"**/*.txt", "1/**/*.gz"}})
+	require.Nil(err)
+	assert.Equal([]string{"/data/1/11/c.gz", "/data/1/b.txt", "/data/a.txt"}, files)
+
+	// Excluded directories are pruned
+	files, err = ListFS(mfs, "/data", ListOptions{Exclude: []string{"1/11/"}})
+	require.Nil(err)
+	assert.Equal([]string{"/data/1/b.txt", "/data/a.txt"}, files)
+
+	files, err = ListFS(mfs, "/data", ListOptions{Hidden: true, Include: []string{"**/*.txt"}})
+	require.Nil(err)
+	assert.Equal([]string{"/data/.git/d.txt", "/data/.hidden.txt", "/data/1/b.txt", "/data/a.txt"}, files)
+
+	dirs, err := ListFS(mfs, "/data", ListOptions{Type: LIST_DIRS, MaxDepth: 2})
+	require.Nil(err)
+	assert.Equal([]string{"/data/1", "/data/1/11", "/data/2", "/data/2/21", "/data/3", "/data/3/31"}, dirs)
+
+	all, err := ListFS(mfs, "/data", ListOptions{Type: LIST_ALL, MinDepth: 2, MaxDepth: 2})
+	require.Nil(err)
+	assert.Equal([]string{"/data/1/11", "/data/1/b.txt", "/data/2/21", "/data/3/31"}, all)
+
+	_, err = ListFS(mfs, "/data", ListOptions{Include: []string{"["}})
+	assert.NotNil(err, "Should have failed on bad pattern")
+	_, err = ListFS(mfs, "/missing", ListOptions{})
+	assert.True(errors.Is(err, fs.ErrNotExist))
+	_, err = ListFS(mfs, "/data/a.txt", ListOptions{})
+	assert.NotNil(err, "Should have failed on file root")
+}
+
+func TestListSymlinks(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := os.MkdirTemp("", "list")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	require.Nil(os.MkdirAll(filepath.Join(dir, "a/b"), 0775))
+	require.Nil(os.MkdirAll(filepath.Join(dir, "other"), 0775))
+	require.Nil(os.WriteFile(filepath.Join(dir, "a/b/x.txt"), []byte("x\n"), 0664))
+	require.Nil(os.WriteFile(filepath.Join(dir, "other/y.txt"), []byte("y\n"), 0664))
+	require.Nil(os.Symlink(filepath.Join(dir, "other"), filepath.Join(dir, "a/link")))
+
+	files, err := List(dir, ListOptions{})
+	require.Nil(err)
+	assert.Equal([]string{
+		filepath.Join(dir, "a/b/x.txt"),
+		filepath.Join(dir, "a/link"),
+		filepath.Join(dir, "other/y.txt"),
+	}, files)
+
+	files, err = List(dir, ListOptions{FollowSymlinks: true})
+	require.Nil(err)
+	assert.Equal([]string{
+		filepath.Join(dir, "a/b/x.txt"),
+		filepath.Join(dir, "a/link/y.txt"),
+		filepath.Join(dir, "other/y.txt"),
+	}, files)
+
+	// A link back to an ancestor is a loop
+	require.Nil(os.Symlink(filepath.Join(dir, "a"), filepath.Join(dir, "a/b/up")))
+	_, err = List(dir, ListOptions{FollowSymlinks: true})
+	assert.True(errors.Is(err, ErrSymlinkLoop))
+
+	var failed []string
+	files, err = List(dir, ListOptions{FollowSymlinks: true, OnError: func(path string, err error) error {
+		failed = append(failed, path)
+		return nil
+	}})
+	require.Nil(err)
+	assert.Equal([]string{filepath.Join(dir, "a/b/up")}, failed)
+	assert.Equal(3, len(files))
+}
+
+func TestWalk(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mfs := NewMemFS()
+	var expected []string
+	for i := 0; i < 20; i++ {
+		dir := fmt.Sprintf("/data/%02d/sub", i)
+		require.Nil(mfs.MkdirAll(dir, 0775))
+		for j := 0; j < 5; j++ {
+			file := fmt.Sprintf("%v/%d.txt", dir, j)
+			require.Nil(mfs.WriteFile(file, []byte("x\n"), 0664))
+			expected = append(expected, file)
+		}
+	}
+
+	channel := make(chan string)
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err = WalkFS(context.Background(), mfs, "/data", ListOptions{Workers: 8}, channel)
+	}()
+	var found []string
+	for file := range channel {
+		found = append(found, file)
+	}
+	<-done
+	require.Nil(err)
+	sort.Strings(found)
+	assert.Equal(expected, found)
+
+	// The sorted wrapper sees the same files
+	files, err := ListFS(mfs, "/data", ListOptions{Workers: 3})
+	require.Nil(err)
+	assert.Equal(expected, files)
+}
+
+func TestWalkCancel(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mfs := NewMemFS()
+	for i := 0; i < 50; i++ {
+		require.Nil(mfs.MkdirAll(fmt.Sprintf("/data/%02d", i), 0775))
+		require.Nil(mfs.WriteFile(fmt.Sprintf("/data/%02d/a.txt", i), []byte("a\n"), 0664))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	channel := make(chan string)
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err = WalkFS(ctx, mfs, "/data", ListOptions{Workers: 4}, channel)
+	}()
+	<-channel
+	cancel()
+	// Drain whatever was already on its way
+	for range channel {
+	}
+	<-done
+	assert.True(errors.Is(err, context.Canceled))
+
+	// Errors stop the walk as well
+	channel = make(chan string, 100)
+	err = WalkFS(context.Background(), mfs, "/data", ListOptions{Include: []string{"["}}, channel)
+	assert.NotNil(err)
+	_, ok := <-channel
+	assert.False(ok, "Channel should have been closed")
+}
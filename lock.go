@@ -0,0 +1,183 @@
+package gocommons
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// LockType is the kind of advisory lock held on a File.
+// Locks are flock(2) locks. They only keep out other processes (or other
+// Opens of the same path) that also lock the file.
+type LockType int
+
+const (
+	LOCK_NONE      LockType = 0
+	LOCK_SHARED    LockType = 1
+	LOCK_EXCLUSIVE LockType = 2
+)
+
+// Timeouts with special meaning for OpenLocked
+const (
+	LOCK_BLOCK   time.Duration = -1
+	LOCK_NOBLOCK time.Duration = 0
+)
+
+var ErrLockTimeout = errors.New("Timed out waiting for lock")
+
+// How long LockWithDeadline waits between attempts at most
+const maxLockPollInterval = 100 * time.Millisecond
+
+func (lt LockType) how() (int, error) {
+	switch lt {
+	case LOCK_SHARED:
+		return syscall.LOCK_SH, nil
+	case LOCK_EXCLUSIVE:
+		return syscall.LOCK_EX, nil
+	}
+	return 0, errors.New(fmt.Sprintf("Invalid LockType %d", lt))
+}
+
+func flock(file *os.File, how int) (err error) {
+	for {
+		if err = syscall.Flock(int(file.Fd()), how); err != syscall.EINTR {
+			return
+		}
+	}
+}
+
+func (f *File) osFile() (*os.File, error) {
+	if f.File == nil {
+		return nil, errors.New(fmt.Sprintf("Locking is not supported for '%v'", f.Path))
+	}
+	return f.File, nil
+}
+
+// Lock blocks until it holds a lock of type lt on f.
+// A lock that is already held is converted to lt.
+func (f *File) Lock(lt LockType) error {
+	how, err := lt.how()
+	if err != nil {
+		return err
+	}
+	file, err := f.osFile()
+	if err != nil {
+		return err
+	}
+	if err = flock(file, how); err != nil {
+		return errors.New(fmt.Sprintf("Failed to lock '%v': %v", f.Path, err))
+	}
+	f.lock = lt
+	return nil
+}
+
+// TryLock attempts to lock f without blocking.
+// It returns false if the lock is held by someone else.
+func (f *File) TryLock(lt LockType) (bool, error) {
+	how, err := lt.how()
+	if err != nil {
+		return false, err
+	}
+	file, err := f.osFile()
+	if err != nil {
+		return false, err
+	}
+	if err = flock(file, how|syscall.LOCK_NB); err != nil {
+		if err == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+		return false, errors.New(fmt.Sprintf("Failed to lock '%v': %v", f.Path, err))
+	}
+	f.lock = lt
+	return true, nil
+}
+
+// LockWithDeadline keeps trying to lock f until deadline.
+// It returns ErrLockTimeout if the lock could not be acquired in time.
+func (f *File) LockWithDeadline(lt LockType, deadline time.Time) error {
+	wait := time.Millisecond
+	for {
+		locked, err := f.TryLock(lt)
+		if err != nil {
+			return err
+		}
+		if locked {
+			return nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return ErrLockTimeout
+		}
+		if wait > remaining {
+			wait = remaining
+		}
+		time.Sleep(wait)
+		if wait *= 2; wait > maxLockPollInterval {
+			wait = maxLockPollInterval
+		}
+	}
+}
+
+// Unlock releases the lock held on f. Close also releases it.
+func (f *File) Unlock() error {
+	file, err := f.osFile()
+	if err != nil {
+		return err
+	}
+	if f.lock == LOCK_NONE {
+		return nil
+	}
+	if err = flock(file, syscall.LOCK_UN); err != nil {
+		return errors.New(fmt.Sprintf("Failed to unlock '%v': %v", f.Path, err))
+	}
+	f.lock = LOCK_NONE
+	return nil
+}
+
+// LockType returns the type of lock currently held on f
+func (f *File) LockType() LockType {
+	return f.lock
+}
+
+// OpenLocked is like Open but also locks the file before returning it.
+// timeout is either LOCK_BLOCK, LOCK_NOBLOCK or how long to wait for
+// the lock. If mode contains os.O_TRUNC, the file is only truncated
+// once the lock is held, so a locked reader never sees it emptied.
+// The lock is released by Close.
+func OpenLocked(filepath string, mode int, gz FileType, lt LockType, timeout time.Duration) (*File, error) {
+	f, err := Open(filepath, mode&^os.O_TRUNC, GZ_FALSE)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case timeout < 0:
+		err = f.Lock(lt)
+	case timeout == 0:
+		var locked bool
+		if locked, err = f.TryLock(lt); err == nil && !locked {
+			err = ErrLockTimeout
+		}
+	default:
+		err = f.LockWithDeadline(lt, time.Now().Add(timeout))
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if mode&os.O_TRUNC != 0 {
+		if err = f.File.Truncate(0); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	f.mode = mode
+	f.gz = gz
+	if gz == GZ_UNKNOWN {
+		f.fixMode()
+	}
+	return f, nil
+}
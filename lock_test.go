@@ -0,0 +1,110 @@
+package gocommons
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := "/tmp/lock-test.txt"
+	defer os.Remove(path)
+
+	f1, err := Open(path, os.O_WRONLY|os.O_CREATE, GZ_FALSE)
+	require.Nil(err)
+	defer f1.Close()
+	f2, err := Open(path, os.O_RDONLY, GZ_FALSE)
+	require.Nil(err)
+	defer f2.Close()
+	f3, err := Open(path, os.O_RDONLY, GZ_FALSE)
+	require.Nil(err)
+	defer f3.Close()
+
+	// Shared locks coexist
+	require.Nil(f2.Lock(LOCK_SHARED))
+	locked, err := f3.TryLock(LOCK_SHARED)
+	assert.Nil(err)
+	assert.True(locked, "Shared locks should not conflict")
+	assert.Equal(LOCK_SHARED, f3.LockType())
+
+	// ..but keep out exclusive ones
+	locked, err = f1.TryLock(LOCK_EXCLUSIVE)
+	assert.Nil(err)
+	assert.False(locked, "Exclusive lock should conflict with shared locks")
+	start := time.Now()
+	err = f1.LockWithDeadline(LOCK_EXCLUSIVE, time.Now().Add(50*time.Millisecond))
+	assert.Equal(ErrLockTimeout, err)
+	assert.True(time.Since(start) >= 50*time.Millisecond, "Gave up too early")
+
+	// Blocked lock goes through once the others let go
+	done := make(chan error)
+	go func() {
+		done <- f1.Lock(LOCK_EXCLUSIVE)
+	}()
+	require.Nil(f2.Unlock())
+	f3.Close()
+	select {
+	case err = <-done:
+		assert.Nil(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for exclusive lock")
+	}
+	assert.Equal(LOCK_EXCLUSIVE, f1.LockType())
+
+	locked, err = f2.TryLock(LOCK_SHARED)
+	assert.Nil(err)
+	assert.False(locked, "Shared lock should conflict with exclusive lock")
+
+	assert.NotNil(f1.Lock(LockType(42)), "Should have failed on invalid LockType")
+
+	mfs := NewMemFS()
+	mfs.WriteFile("x", []byte("x"), 0664)
+	mf, err := OpenFS(mfs, "x", os.O_RDONLY, GZ_FALSE)
+	require.Nil(err)
+	assert.NotNil(mf.Lock(LOCK_SHARED), "MemFS files cannot be locked")
+}
+
+func TestOpenLocked(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := "/tmp/open-locked-test.gz"
+	defer os.Remove(path)
+
+	f, err := OpenLocked(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, GZ_UNKNOWN, LOCK_EXCLUSIVE, LOCK_BLOCK)
+	require.Nil(err)
+	assert.Equal(GZ_TRUE, f.gz, "FileType should come from the suffix")
+	writer, err := f.Writer(0)
+	require.Nil(err)
+	writer.Write([]byte("Hello World\n"))
+	require.Nil(writer.Close())
+
+	// The truncate must wait for the lock
+	_, err = OpenLocked(path, os.O_WRONLY|os.O_TRUNC, GZ_UNKNOWN, LOCK_EXCLUSIVE, LOCK_NOBLOCK)
+	assert.Equal(ErrLockTimeout, err)
+	_, err = OpenLocked(path, os.O_RDONLY, GZ_UNKNOWN, LOCK_SHARED, 20*time.Millisecond)
+	assert.Equal(ErrLockTimeout, err)
+
+	f.Close()
+	assert.Equal(LOCK_NONE, f.LockType())
+
+	r, err := OpenLocked(path, os.O_RDONLY, GZ_UNKNOWN, LOCK_SHARED, LOCK_NOBLOCK)
+	require.Nil(err)
+	defer r.Close()
+	assert.Equal(GZ_TRUE, r.gz)
+	ok, err := CheckFileContentsMatch(r, "Hello World", true)
+	assert.True(ok, "Contents did not match: %v", err)
+
+	_, err = OpenLocked("/tmp/does/not/exist", os.O_RDONLY, GZ_FALSE, LOCK_SHARED, LOCK_BLOCK)
+	assert.NotNil(err)
+}
@@ -0,0 +1,164 @@
+package gocommons
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// MappedFile is a File whose contents are mapped into memory.
+// Only plain files are mapped. Compressed files are opened normally and
+// Lines falls back to decompressing them.
+// The mapping is read-only and must not be used after Close.
+type MappedFile struct {
+	*File
+	data   []byte
+	mapped bool
+}
+
+// OpenMapped opens path for reading and maps it into memory if it is a
+// plain file. gz works as in Open.
+func OpenMapped(path string, gz FileType) (*MappedFile, error) {
+	f, err := Open(path, os.O_RDONLY, gz)
+	if err != nil {
+		return nil, err
+	}
+	m := &MappedFile{File: f}
+	if f.gz != GZ_FALSE || f.File == nil {
+		return m, nil
+	}
+
+	stat, err := f.File.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	m.mapped = true
+	if stat.Size() == 0 {
+		// Empty files cannot be mapped. There is nothing to map anyway
+		return m, nil
+	}
+	if m.data, err = syscall.Mmap(int(f.File.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED); err != nil {
+		f.Close()
+		return nil, errors.New(fmt.Sprintf("Failed to map '%v': %v", path, err))
+	}
+	return m, nil
+}
+
+// Mapped reports whether the file is mapped into memory
+func (m *MappedFile) Mapped() bool {
+	return m.mapped
+}
+
+// Bytes returns the mapped contents. It is nil if the file is not mapped.
+func (m *MappedFile) Bytes() []byte {
+	return m.data
+}
+
+// ReadAt reads from the mapped contents
+func (m *MappedFile) ReadAt(b []byte, offset int64) (int, error) {
+	if !m.mapped {
+		return 0, errors.New(fmt.Sprintf("'%v' is not mapped", m.Path))
+	}
+	if offset < 0 {
+		return 0, errors.New(fmt.Sprintf("Negative offset %d", offset))
+	}
+	if offset >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, m.data[offset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// RawReader reads from the mapping if there is one
+func (m *MappedFile) RawReader() (io.Reader, error) {
+	if m.mapped {
+		return bytes.NewReader(m.data), nil
+	}
+	return m.File.RawReader()
+}
+
+func (m *MappedFile) Reader(bufsize int) (*bufio.Scanner, error) {
+	reader, err := m.RawReader()
+	if err != nil {
+		return nil, err
+	}
+	return newScanner(reader, bufsize), err
+}
+
+// Lines returns an iterator over the lines of the file.
+// For mapped files, the lines are slices of the mapping.
+func (m *MappedFile) Lines() (*LineIterator, error) {
+	if m.mapped {
+		return &LineIterator{data: m.data}, nil
+	}
+	scanner, err := m.File.Reader(0)
+	if err != nil {
+		return nil, err
+	}
+	return &LineIterator{scanner: scanner}, nil
+}
+
+func (m *MappedFile) Close() {
+	if m.data != nil {
+		syscall.Munmap(m.data)
+		m.data = nil
+	}
+	m.File.Close()
+}
+
+// LineIterator iterates over lines like a bufio.Scanner using ScanLines.
+// Over a mapping, Bytes returns a slice of the mapping without copying
+// and stays valid until the file is closed. Otherwise it is only valid
+// until the next call to Scan.
+type LineIterator struct {
+	data    []byte
+	pos     int
+	scanner *bufio.Scanner
+	line    []byte
+}
+
+func (it *LineIterator) Scan() bool {
+	if it.scanner != nil {
+		if !it.scanner.Scan() {
+			return false
+		}
+		it.line = it.scanner.Bytes()
+		return true
+	}
+	if it.pos >= len(it.data) {
+		return false
+	}
+	rest := it.data[it.pos:]
+	if idx := bytes.IndexByte(rest, '\n'); idx >= 0 {
+		it.line = rest[:idx]
+		it.pos += idx + 1
+	} else {
+		it.line = rest
+		it.pos = len(it.data)
+	}
+	it.line = bytes.TrimSuffix(it.line, []byte{'\r'})
+	return true
+}
+
+func (it *LineIterator) Bytes() []byte {
+	return it.line
+}
+
+func (it *LineIterator) Text() string {
+	return string(it.line)
+}
+
+func (it *LineIterator) Err() error {
+	if it.scanner != nil {
+		return it.scanner.Err()
+	}
+	return nil
+}
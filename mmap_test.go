@@ -0,0 +1,88 @@
+package gocommons
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mappedLines(t *testing.T, m *MappedFile) (lines []string) {
+	it, err := m.Lines()
+	require.Nil(t, err)
+	for it.Scan() {
+		lines = append(lines, it.Text())
+	}
+	require.Nil(t, it.Err())
+	return
+}
+
+func TestOpenMapped(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := "/tmp/open-mapped-test.txt"
+	defer os.Remove(path)
+	contents := "first\r\nsecond\n\nlast"
+	require.Nil(os.WriteFile(path, []byte(contents), 0664))
+
+	m, err := OpenMapped(path, GZ_UNKNOWN)
+	require.Nil(err)
+	defer m.Close()
+	assert.True(m.Mapped())
+	assert.Equal(contents, string(m.Bytes()))
+	assert.Equal([]string{"first", "second", "", "last"}, mappedLines(t, m))
+
+	// Lines point into the mapping
+	it, _ := m.Lines()
+	it.Scan()
+	assert.True(&it.Bytes()[0] == &m.Bytes()[0], "Line should not be a copy")
+
+	buf := make([]byte, 6)
+	n, err := m.ReadAt(buf, 7)
+	assert.Nil(err)
+	assert.Equal("second", string(buf[:n]))
+	n, err = m.ReadAt(buf, int64(len(contents)-2))
+	assert.Equal(io.EOF, err)
+	assert.Equal("st", string(buf[:n]))
+
+	reader, err := m.RawReader()
+	require.Nil(err)
+	all, _ := io.ReadAll(reader)
+	assert.Equal(contents, string(all))
+
+	empty := "/tmp/open-mapped-empty.txt"
+	defer os.Remove(empty)
+	require.Nil(os.WriteFile(empty, nil, 0664))
+	e, err := OpenMapped(empty, GZ_FALSE)
+	require.Nil(err)
+	defer e.Close()
+	assert.True(e.Mapped())
+	assert.Nil(mappedLines(t, e))
+}
+
+func TestOpenMappedGzip(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data := gzipTestData(1000)
+	path := "/tmp/open-mapped-test.gz"
+	writeGzipTestFile(t, path, data)
+	defer os.Remove(path)
+
+	m, err := OpenMapped(path, GZ_UNKNOWN)
+	require.Nil(err)
+	defer m.Close()
+	assert.False(m.Mapped())
+	assert.Nil(m.Bytes())
+	assert.Equal(strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), mappedLines(t, m))
+	_, err = m.ReadAt(make([]byte, 1), 0)
+	assert.NotNil(err)
+}
@@ -0,0 +1,215 @@
+package gocommons
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+const DEFAULT_PIPELINE_BATCH = 1024
+
+// PipelineParams configure RunPipeline. The zero value is usable.
+type PipelineParams struct {
+	// Number of goroutines running the MapFunc. 0 uses GOMAXPROCS
+	Workers int
+	// Lines handed to a worker at a time. 0 uses DEFAULT_PIPELINE_BATCH
+	BatchSize int
+	// Emit results as soon as their batch is done instead of in input order.
+	// Either way, at most 2*Workers batches are in flight, so a slow batch
+	// holds up reading rather than piling up results.
+	Unordered bool
+	// Passed on to AsyncReadContext. nil splits on lines
+	Split   bufio.SplitFunc
+	Bufsize int
+}
+
+// MapFunc transforms a single line.
+// Lines for which keep is false are dropped. Returning an error stops the
+// pipeline.
+type MapFunc func(line string) (out string, keep bool, err error)
+
+// PipelineError is returned when a MapFunc or sink fails
+type PipelineError struct {
+	// The input line that was being processed, starting at 1
+	Line int64
+	Err  error
+}
+
+func (e *PipelineError) Error() string {
+	return fmt.Sprintf("Failed to process line %d: %v", e.Line, e.Err)
+}
+
+func (e *PipelineError) Unwrap() error {
+	return e.Err
+}
+
+type pipelineBatch struct {
+	seq   int64
+	first int64
+	lines []string
+	// Input line of every entry of lines. Only set in results
+	lineNos []int64
+}
+
+// RunPipeline reads the lines of in, runs fn over them on several workers
+// and writes the results to out, one per line. out is typically a Writer.
+// It returns the first error from reading, fn or writing.
+func RunPipeline(ctx context.Context, in *File, out io.Writer, fn MapFunc, params PipelineParams) error {
+	return RunPipelineFunc(ctx, in, fn, params, func(line string) (err error) {
+		if _, err = io.WriteString(out, line); err == nil {
+			_, err = io.WriteString(out, "\n")
+		}
+		return
+	})
+}
+
+// RunPipelineFunc is like RunPipeline but hands every result to sink.
+// sink is only ever called from the calling goroutine.
+func RunPipelineFunc(ctx context.Context, in *File, fn MapFunc, params PipelineParams, sink func(string) error) error {
+	if params.Workers <= 0 {
+		params.Workers = runtime.GOMAXPROCS(0)
+	}
+	if params.BatchSize <= 0 {
+		params.BatchSize = DEFAULT_PIPELINE_BATCH
+	}
+	if params.Split == nil {
+		params.Split = bufio.ScanLines
+	}
+	if params.Bufsize == 0 {
+		params.Bufsize = 1048576
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The first error wins and stops everything else
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	lines := make(chan string, params.BatchSize)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- in.AsyncReadContext(ctx, params.Split, params.Bufsize, lines)
+	}()
+
+	// A slot is taken for every batch until its results have been emitted
+	slots := make(chan struct{}, 2*params.Workers)
+	batches := make(chan *pipelineBatch, params.Workers)
+	go func() {
+		defer close(batches)
+		batch := &pipelineBatch{first: 1}
+		var lineNo int64
+		send := func() bool {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return false
+			}
+			select {
+			case batches <- batch:
+			case <-ctx.Done():
+				return false
+			}
+			batch = &pipelineBatch{seq: batch.seq + 1, first: lineNo + 1}
+			return true
+		}
+		for line := range lines {
+			lineNo++
+			batch.lines = append(batch.lines, line)
+			if len(batch.lines) == params.BatchSize && !send() {
+				break
+			}
+		}
+		if len(batch.lines) > 0 {
+			send()
+		}
+		// Let the reader finish if we stopped early
+		for range lines {
+		}
+	}()
+
+	results := make(chan *pipelineBatch, params.Workers)
+	var wg sync.WaitGroup
+	for w := 0; w < params.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				if ctx.Err() != nil {
+					continue
+				}
+				result := &pipelineBatch{seq: batch.seq, lines: batch.lines[:0]}
+				for idx, line := range batch.lines {
+					out, keep, err := fn(line)
+					if err != nil {
+						fail(&PipelineError{batch.first + int64(idx), err})
+						break
+					}
+					if keep {
+						result.lines = append(result.lines, out)
+						result.lineNos = append(result.lineNos, batch.first+int64(idx))
+					}
+				}
+				select {
+				case results <- result:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	emit := func(batch *pipelineBatch) {
+		for idx, line := range batch.lines {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := sink(line); err != nil {
+				fail(&PipelineError{batch.lineNos[idx], err})
+				return
+			}
+		}
+	}
+	pending := make(map[int64]*pipelineBatch)
+	var next int64
+	for result := range results {
+		if params.Unordered {
+			emit(result)
+			<-slots
+			continue
+		}
+		pending[result.seq] = result
+		for {
+			batch, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			emit(batch)
+			<-slots
+			next++
+		}
+	}
+
+	err := <-readErr
+	if firstErr != nil {
+		return firstErr
+	}
+	if err == nil {
+		// Reading may have finished before ctx was cancelled from outside
+		err = ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,179 @@
+package gocommons
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/synctest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pipelineTestInput(t *testing.T, path string) []string {
+	data := gzipTestData(20000)
+	writeGzipTestFile(t, path, data)
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// Keep every line that does not end in 7 and reverse its words
+func pipelineTestMap(line string) (string, bool, error) {
+	if strings.HasSuffix(line, "7") {
+		return "", false, nil
+	}
+	words := strings.Fields(line)
+	for i, j := 0, len(words)-1; i < j; i, j = i+1, j-1 {
+		words[i], words[j] = words[j], words[i]
+	}
+	return strings.Join(words, " "), true, nil
+}
+
+func TestRunPipeline(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := "/tmp/pipeline-test.gz"
+	input := pipelineTestInput(t, path)
+	defer os.Remove(path)
+
+	var expected []string
+	for _, line := range input {
+		if out, keep, _ := pipelineTestMap(line); keep {
+			expected = append(expected, out)
+		}
+	}
+
+	for _, unordered := range []bool{false, true} {
+		f, err := Open(path, os.O_RDONLY, GZ_UNKNOWN)
+		require.Nil(err)
+		out := new(bytes.Buffer)
+		params := PipelineParams{Workers: 4, BatchSize: 7, Unordered: unordered}
+		err = RunPipeline(context.Background(), f, out, pipelineTestMap, params)
+		f.Close()
+		require.Nil(err)
+
+		got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		if unordered {
+			sort.Strings(got)
+			sorted := append([]string{}, expected...)
+			sort.Strings(sorted)
+			assert.Equal(sorted, got)
+		} else {
+			assert.Equal(expected, got)
+		}
+	}
+}
+
+func TestRunPipelineErrors(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := "/tmp/pipeline-errors-test.gz"
+	input := pipelineTestInput(t, path)
+	defer os.Remove(path)
+
+	errBad := errors.New("bad line")
+	failAt := func(bad string) MapFunc {
+		return func(line string) (string, bool, error) {
+			if line == bad {
+				return "", false, errBad
+			}
+			return line, true, nil
+		}
+	}
+
+	f, err := Open(path, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	var emitted int
+	err = RunPipelineFunc(context.Background(), f, failAt(input[12344]), PipelineParams{Workers: 3, BatchSize: 100}, func(string) error {
+		emitted++
+		return nil
+	})
+	f.Close()
+	assert.True(errors.Is(err, errBad), "%v", err)
+	var pipelineErr *PipelineError
+	require.True(errors.As(err, &pipelineErr))
+	assert.Equal(int64(12345), pipelineErr.Line)
+	assert.True(emitted <= 12344, "Lines after the error should not be emitted")
+
+	// Sink errors
+	f, err = Open(path, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	errFull := errors.New("full")
+	err = RunPipelineFunc(context.Background(), f, pipelineTestMap, PipelineParams{}, func(line string) error {
+		return errFull
+	})
+	f.Close()
+	assert.True(errors.Is(err, errFull), "%v", err)
+	require.True(errors.As(err, &pipelineErr))
+	assert.Equal(int64(1), pipelineErr.Line)
+
+	// Cancellation from outside
+	f, err = Open(path, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	emitted = 0
+	err = RunPipelineFunc(ctx, f, pipelineTestMap, PipelineParams{BatchSize: 10}, func(line string) error {
+		if emitted++; emitted == 100 {
+			cancel()
+		}
+		return nil
+	})
+	f.Close()
+	assert.True(errors.Is(err, context.Canceled), "%v", err)
+	assert.True(emitted < len(input))
+}
+
+func TestRunPipelineBounded(t *testing.T) {
+	t.Parallel()
+
+	data := gzipTestData(20000)
+	path := "/tmp/pipeline-bounded.txt"
+	require.Nil(t, os.WriteFile(path, data, 0664))
+	defer os.Remove(path)
+	input := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+
+	synctest.Test(t, func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		// The first batch is held up. The others must not pile up behind it
+		release := make(chan struct{})
+		var mapped int64
+		fn := func(line string) (string, bool, error) {
+			if line == input[0] {
+				<-release
+			}
+			atomic.AddInt64(&mapped, 1)
+			return line, true, nil
+		}
+
+		f, err := Open(path, os.O_RDONLY, GZ_FALSE)
+		require.Nil(err)
+		defer f.Close()
+		params := PipelineParams{Workers: 2, BatchSize: 10}
+		result := make(chan error, 1)
+		out := new(bytes.Buffer)
+		go func() {
+			result <- RunPipeline(context.Background(), f, out, fn, params)
+		}()
+
+		// Returns once everything is stuck behind the first batch
+		synctest.Wait()
+		n := atomic.LoadInt64(&mapped)
+		assert.True(n <= int64(2*params.Workers*params.BatchSize), "Mapped %d lines", n)
+
+		close(release)
+		require.Nil(<-result)
+		assert.Equal(string(data), out.String())
+	})
+}
@@ -0,0 +1,202 @@
+package gocommons
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Phases reported in Progress
+const (
+	PROGRESS_READ  = "read"
+	PROGRESS_SPLIT = "split"
+	PROGRESS_MERGE = "merge"
+)
+
+const DEFAULT_PROGRESS_INTERVAL = time.Second
+
+// Progress is a snapshot of how far a read has got
+type Progress struct {
+	Phase string
+	// Bytes consumed from the file(s). For compressed files these are
+	// compressed bytes so that they can be compared with TotalBytes.
+	Bytes      int64
+	TotalBytes int64
+	Lines      int64
+	Elapsed    time.Duration
+	// Set on the last report of a phase
+	Done bool
+}
+
+// ProgressFunc receives Progress reports. It is called from the goroutine
+// doing the reading and should return quickly.
+type ProgressFunc func(Progress)
+
+// Rate returns the number of bytes consumed per second
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Elapsed.Seconds()
+}
+
+// LineRate returns the number of lines per second
+func (p Progress) LineRate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Lines) / p.Elapsed.Seconds()
+}
+
+// Fraction returns how much of TotalBytes has been consumed.
+// It is 0 if the total is unknown.
+func (p Progress) Fraction() float64 {
+	if p.TotalBytes <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / float64(p.TotalBytes)
+}
+
+// ETA estimates the time left assuming the rate stays the same.
+// It is -1 if it cannot be estimated yet.
+func (p Progress) ETA() time.Duration {
+	if p.Done {
+		return 0
+	}
+	if p.TotalBytes <= 0 || p.Bytes <= 0 {
+		return -1
+	}
+	remaining := p.TotalBytes - p.Bytes
+	if remaining < 0 {
+		remaining = 0
+	}
+	return time.Duration(float64(p.Elapsed) * float64(remaining) / float64(p.Bytes))
+}
+
+func (p Progress) String() string {
+	s := fmt.Sprintf("%v: %d lines, %d bytes", p.Phase, p.Lines, p.Bytes)
+	if p.TotalBytes > 0 {
+		s += fmt.Sprintf(" of %d (%.1f%%)", p.TotalBytes, 100*p.Fraction())
+	}
+	s += fmt.Sprintf(", %.1f MB/s", p.Rate()/(1024*1024))
+	if eta := p.ETA(); eta > 0 {
+		s += fmt.Sprintf(", ETA %v", eta.Round(time.Second))
+	}
+	return s
+}
+
+// progressTracker counts the bytes read through its readers and the
+// lines added to it and reports to observer at most every interval
+type progressTracker struct {
+	phase    string
+	total    int64
+	observer ProgressFunc
+	interval time.Duration
+	start    time.Time
+
+	bytes int64
+	lines int64
+
+	mutex sync.Mutex
+	last  time.Time
+	done  bool
+}
+
+// newProgressTracker returns nil if there is no observer.
+// All methods are no-ops on a nil tracker.
+func newProgressTracker(phase string, total int64, observer ProgressFunc, interval time.Duration) *progressTracker {
+	if observer == nil {
+		return nil
+	}
+	if interval <= 0 {
+		interval = DEFAULT_PROGRESS_INTERVAL
+	}
+	now := time.Now()
+	return &progressTracker{phase: phase, total: total, observer: observer, interval: interval, start: now, last: now}
+}
+
+type progressReader struct {
+	io.Reader
+	pt *progressTracker
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.Reader.Read(b)
+	atomic.AddInt64(&pr.pt.bytes, int64(n))
+	pr.pt.maybeReport()
+	return n, err
+}
+
+// wrap counts the bytes read from r
+func (pt *progressTracker) wrap(r io.Reader) io.Reader {
+	if pt == nil {
+		return r
+	}
+	return &progressReader{r, pt}
+}
+
+func (pt *progressTracker) addLines(n int64) {
+	if pt != nil {
+		atomic.AddInt64(&pt.lines, n)
+	}
+}
+
+func (pt *progressTracker) snapshot(now time.Time) Progress {
+	return Progress{
+		Phase:      pt.phase,
+		Bytes:      atomic.LoadInt64(&pt.bytes),
+		TotalBytes: pt.total,
+		Lines:      atomic.LoadInt64(&pt.lines),
+		Elapsed:    now.Sub(pt.start),
+		Done:       pt.done,
+	}
+}
+
+// maybeReport only looks at the clock. It is called once per read rather
+// than once per line to keep it cheap.
+func (pt *progressTracker) maybeReport() {
+	now := time.Now()
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+	if pt.done || now.Sub(pt.last) < pt.interval {
+		return
+	}
+	pt.last = now
+	pt.observer(pt.snapshot(now))
+}
+
+// finish sends the final report. Later calls do nothing.
+func (pt *progressTracker) finish() {
+	if pt == nil {
+		return
+	}
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+	if pt.done {
+		return
+	}
+	pt.done = true
+	pt.observer(pt.snapshot(time.Now()))
+}
+
+// SetProgress makes AsyncRead and AsyncReadWithBufsize report to observer
+// every interval while they read f. An interval of 0 picks
+// DEFAULT_PROGRESS_INTERVAL. A nil observer turns reporting off.
+func (f *File) SetProgress(observer ProgressFunc, interval time.Duration) {
+	f.progress = observer
+	f.progressInterval = interval
+}
+
+// newProgressTracker returns a tracker for reading all of f
+func (f *File) newProgressTracker(phase string) *progressTracker {
+	if f.progress == nil {
+		return nil
+	}
+	var total int64
+	if stat, err := f.handle.Stat(); err == nil {
+		total = stat.Size()
+	}
+	return newProgressTracker(phase, total, f.progress, f.progressInterval)
+}
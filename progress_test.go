@@ -0,0 +1,135 @@
+package gocommons
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgress(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	p := Progress{Phase: PROGRESS_READ, Bytes: 250, TotalBytes: 1000, Lines: 10, Elapsed: 10 * time.Second}
+	assert.Equal(25.0, p.Rate())
+	assert.Equal(1.0, p.LineRate())
+	assert.Equal(0.25, p.Fraction())
+	assert.Equal(30*time.Second, p.ETA())
+	assert.Contains(p.String(), "25.0%")
+
+	p.TotalBytes = 0
+	assert.Equal(time.Duration(-1), p.ETA(), "ETA is unknown without a total")
+	p.Done = true
+	assert.Equal(time.Duration(0), p.ETA())
+}
+
+func TestAsyncReadProgress(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data := gzipTestData(20000)
+	path := "/tmp/async-read-progress.gz"
+	writeGzipTestFile(t, path, data)
+	defer os.Remove(path)
+	stat, err := os.Stat(path)
+	require.Nil(err)
+
+	f, err := Open(path, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	defer f.Close()
+
+	var reports []Progress
+	f.SetProgress(func(p Progress) {
+		reports = append(reports, p)
+	}, time.Nanosecond)
+
+	channel := make(chan string, 100)
+	go f.AsyncReadWithBufsize(bufio.ScanLines, 0, channel)
+	lines := 0
+	for range channel {
+		lines++
+	}
+	assert.Equal(20000, lines)
+
+	require.True(len(reports) > 1, "Expected reports along the way")
+	last := reports[len(reports)-1]
+	assert.True(last.Done)
+	assert.Equal(PROGRESS_READ, last.Phase)
+	assert.Equal(int64(lines), last.Lines)
+	assert.Equal(stat.Size(), last.TotalBytes)
+	assert.Equal(stat.Size(), last.Bytes, "All compressed bytes should have been consumed")
+	for idx := 1; idx < len(reports); idx++ {
+		assert.True(reports[idx].Bytes >= reports[idx-1].Bytes, "Bytes went backwards")
+		assert.False(reports[idx-1].Done, "Only the last report is done")
+	}
+}
+
+func TestExternalSortProgress(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	input := "/tmp/sort-progress-input.txt"
+	var contents []byte
+	for i := 0; i < 10000; i++ {
+		contents = append(contents, fmt.Sprintf("%d\n", (i*7919)%10000)...)
+	}
+	require.Nil(os.WriteFile(input, contents, 0664))
+	defer os.Remove(input)
+
+	var mutex sync.Mutex
+	final := make(map[string]Progress)
+	sort_params := IntSortParams
+	sort_params.Lines = make(SortCollection, 0)
+	sort_params.ProgressInterval = time.Nanosecond
+	sort_params.Progress = func(p Progress) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if p.Done {
+			final[p.Phase] = p
+		}
+	}
+
+	chunks, err := ExternalSort(input, 4096, sort_params)
+	require.Nil(err)
+	defer func() {
+		for _, chunk := range chunks {
+			os.Remove(chunk)
+		}
+	}()
+
+	var chunkBytes int64
+	for _, chunk := range chunks {
+		stat, err := os.Stat(chunk)
+		require.Nil(err)
+		chunkBytes += stat.Size()
+	}
+
+	merge_out_channel := make(chan SortInterface, 10000)
+	NWayMergeGenerator(chunks, sort_params, merge_out_channel, func(channel chan SortInterface, quit chan bool) {
+		for range channel {
+		}
+		quit <- true
+	})
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	split := final[PROGRESS_SPLIT]
+	assert.Equal(int64(10000), split.Lines)
+	assert.Equal(int64(len(contents)), split.TotalBytes)
+	assert.Equal(int64(len(contents)), split.Bytes)
+	merge := final[PROGRESS_MERGE]
+	assert.Equal(int64(10000), merge.Lines)
+	assert.Equal(chunkBytes, merge.TotalBytes)
+	assert.Equal(chunkBytes, merge.Bytes)
+}
@@ -0,0 +1,398 @@
+package gocommons
+
+import (
+	"bufio"
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// RecordFormat is the format of the records read by RecordReader and
+// written by RecordWriter
+type RecordFormat int
+
+const (
+	// Comma separated values with a header row and RFC 4180 quoting
+	CSV RecordFormat = iota
+	// Tab separated values with a header row. Fields cannot contain tabs
+	// or newlines and are not quoted
+	TSV
+	// One JSON object per line
+	JSONL
+)
+
+func (rf RecordFormat) String() string {
+	switch rf {
+	case CSV:
+		return "csv"
+	case TSV:
+		return "tsv"
+	case JSONL:
+		return "jsonl"
+	}
+	return fmt.Sprintf("RecordFormat(%d)", int(rf))
+}
+
+// RecordError is returned when a record cannot be read or written
+type RecordError struct {
+	Path string
+	// Line of the file the record is on, starting at 1
+	Line int
+	// For values that could not be converted, the 1-based index of the
+	// column and its name. For malformed input, the byte offset in the
+	// line as reported by the parser. 0 if unknown
+	Column int
+	Field  string
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	s := fmt.Sprintf("%v:%d", e.Path, e.Line)
+	if e.Column > 0 {
+		s += fmt.Sprintf(":%d", e.Column)
+	}
+	if e.Field != "" {
+		s += fmt.Sprintf(" (%v)", e.Field)
+	}
+	return fmt.Sprintf("%v: %v", s, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// recordField is a struct field that maps to a column.
+// Columns are named by the `csv` tag of the field or the field's name.
+// A tag of "-" skips the field.
+type recordField struct {
+	name  string
+	index int
+}
+
+func recordFields(t reflect.Type) (fields []recordField) {
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("csv"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		fields = append(fields, recordField{name, idx})
+	}
+	return
+}
+
+// structValue checks that v is a pointer to a struct and returns the struct
+func structValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, errors.New(fmt.Sprintf("Expected a pointer to a struct, got %T", v))
+	}
+	return rv.Elem(), nil
+}
+
+func setRecordField(fv reflect.Value, s string) error {
+	if fv.CanAddr() {
+		if u, ok := fv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(s))
+		}
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return errors.New(fmt.Sprintf("Unsupported field type %v", fv.Type()))
+	}
+	return nil
+}
+
+func formatRecordField(fv reflect.Value) (string, error) {
+	if m, ok := fv.Interface().(encoding.TextMarshaler); ok {
+		b, err := m.MarshalText()
+		return string(b), err
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(fv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(fv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(fv.Float(), 'g', -1, fv.Type().Bits()), nil
+	}
+	return "", errors.New(fmt.Sprintf("Unsupported field type %v", fv.Type()))
+}
+
+// RecordReader decodes records of a File into structs.
+// For CSV and TSV, the first row is the header and columns are matched
+// to fields by name. Columns without a field are ignored and fields
+// without a column are left alone. JSONL uses encoding/json and its tags.
+type RecordReader struct {
+	Format RecordFormat
+	path   string
+	csv    *csv.Reader
+	lines  *bufio.Scanner
+	line   int
+	header []string
+	// Field index for every column, per struct type
+	columns map[reflect.Type][]int
+}
+
+// RecordReader returns a reader of the records in f
+func (f *File) RecordReader(format RecordFormat) (*RecordReader, error) {
+	raw, err := f.RawReader()
+	if err != nil {
+		return nil, err
+	}
+	rr := &RecordReader{Format: format, path: f.Path, columns: make(map[reflect.Type][]int)}
+	switch format {
+	case CSV:
+		rr.csv = csv.NewReader(raw)
+		rr.csv.ReuseRecord = true
+	case TSV, JSONL:
+		rr.lines = newScanner(raw, 0)
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown RecordFormat %d", format))
+	}
+	return rr, nil
+}
+
+// Header returns the column names of a CSV or TSV file.
+// It reads the header if no record has been read yet.
+func (rr *RecordReader) Header() ([]string, error) {
+	if rr.header == nil && rr.Format != JSONL {
+		record, _, err := rr.next()
+		if err != nil {
+			return nil, err
+		}
+		rr.header = append([]string(nil), record...)
+	}
+	return rr.header, nil
+}
+
+// next returns the fields of the next row and the line it starts on
+func (rr *RecordReader) next() ([]string, int, error) {
+	if rr.csv != nil {
+		record, err := rr.csv.Read()
+		if err != nil {
+			if parseErr, ok := err.(*csv.ParseError); ok {
+				return nil, 0, &RecordError{rr.path, parseErr.Line, parseErr.Column, "", parseErr.Err}
+			}
+			return nil, 0, err
+		}
+		line, _ := rr.csv.FieldPos(0)
+		return record, line, nil
+	}
+	text, err := rr.nextLine()
+	if err != nil {
+		return nil, 0, err
+	}
+	return strings.Split(text, "\t"), rr.line, nil
+}
+
+// nextLine returns the next non-empty line
+func (rr *RecordReader) nextLine() (string, error) {
+	for rr.lines.Scan() {
+		rr.line++
+		if text := rr.lines.Text(); text != "" {
+			return text, nil
+		}
+	}
+	if err := rr.lines.Err(); err != nil {
+		return "", &RecordError{rr.path, rr.line + 1, 0, "", err}
+	}
+	return "", io.EOF
+}
+
+// Read decodes the next record into v, which must point to a struct.
+// It returns io.EOF when there are no more records.
+func (rr *RecordReader) Read(v interface{}) error {
+	sv, err := structValue(v)
+	if err != nil {
+		return err
+	}
+	if rr.Format == JSONL {
+		return rr.readJSON(v)
+	}
+	if _, err := rr.Header(); err != nil {
+		return err
+	}
+
+	columns, ok := rr.columns[sv.Type()]
+	if !ok {
+		byName := make(map[string]int)
+		for _, field := range recordFields(sv.Type()) {
+			byName[field.name] = field.index
+		}
+		columns = make([]int, len(rr.header))
+		for idx, name := range rr.header {
+			if columns[idx], ok = byName[name]; !ok {
+				columns[idx] = -1
+			}
+		}
+		rr.columns[sv.Type()] = columns
+	}
+
+	record, line, err := rr.next()
+	if err != nil {
+		return err
+	}
+	if len(record) != len(rr.header) {
+		return &RecordError{rr.path, line, 0, "", errors.New(fmt.Sprintf("Expected %d fields, got %d", len(rr.header), len(record)))}
+	}
+	for idx, value := range record {
+		if columns[idx] < 0 {
+			continue
+		}
+		if err := setRecordField(sv.Field(columns[idx]), value); err != nil {
+			return &RecordError{rr.path, line, idx + 1, rr.header[idx], err}
+		}
+	}
+	return nil
+}
+
+func (rr *RecordReader) readJSON(v interface{}) error {
+	text, err := rr.nextLine()
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal([]byte(text), v); err != nil {
+		rerr := &RecordError{Path: rr.path, Line: rr.line, Err: err}
+		switch jerr := err.(type) {
+		case *json.SyntaxError:
+			rerr.Column = int(jerr.Offset)
+		case *json.UnmarshalTypeError:
+			rerr.Column = int(jerr.Offset)
+			rerr.Field = jerr.Field
+		}
+		return rerr
+	}
+	return nil
+}
+
+// RecordWriter encodes structs as records of a File.
+// For CSV and TSV, the header is written along with the first record.
+type RecordWriter struct {
+	Format RecordFormat
+	path   string
+	writer Writer
+	csv    *csv.Writer
+	fields []recordField
+	line   int
+}
+
+// RecordWriter returns a writer of records to f.
+// Close must be called to flush everything to f.
+func (f *File) RecordWriter(format RecordFormat, bufsize int) (*RecordWriter, error) {
+	if format != CSV && format != TSV && format != JSONL {
+		return nil, errors.New(fmt.Sprintf("Unknown RecordFormat %d", format))
+	}
+	writer, err := f.Writer(bufsize)
+	if err != nil {
+		return nil, err
+	}
+	rw := &RecordWriter{Format: format, path: f.Path, writer: writer}
+	if format == CSV {
+		rw.csv = csv.NewWriter(writer)
+	}
+	return rw, nil
+}
+
+// Write encodes v, which must be a struct or point to one
+func (rw *RecordWriter) Write(v interface{}) error {
+	if rw.Format == JSONL {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return &RecordError{rw.path, rw.line + 1, 0, "", err}
+		}
+		rw.line++
+		_, err = rw.writer.Write(append(b, '\n'))
+		return err
+	}
+
+	sv := reflect.Indirect(reflect.ValueOf(v))
+	if sv.Kind() != reflect.Struct {
+		return errors.New(fmt.Sprintf("Expected a struct, got %T", v))
+	}
+	if rw.fields == nil {
+		rw.fields = recordFields(sv.Type())
+		header := make([]string, len(rw.fields))
+		for idx, field := range rw.fields {
+			header[idx] = field.name
+		}
+		if err := rw.writeRow(header); err != nil {
+			return err
+		}
+	}
+	row := make([]string, len(rw.fields))
+	for idx, field := range rw.fields {
+		value, err := formatRecordField(sv.Field(field.index))
+		if err != nil {
+			return &RecordError{rw.path, rw.line + 1, idx + 1, field.name, err}
+		}
+		row[idx] = value
+	}
+	return rw.writeRow(row)
+}
+
+func (rw *RecordWriter) writeRow(row []string) error {
+	rw.line++
+	if rw.csv != nil {
+		return rw.csv.Write(row)
+	}
+	for idx, value := range row {
+		if strings.ContainsAny(value, "\t\n\r") {
+			return &RecordError{rw.path, rw.line, idx + 1, "", errors.New("TSV fields cannot contain tabs or newlines")}
+		}
+	}
+	_, err := io.WriteString(rw.writer, strings.Join(row, "\t")+"\n")
+	return err
+}
+
+// Close flushes all records and closes the underlying Writer
+func (rw *RecordWriter) Close() error {
+	if rw.csv != nil {
+		rw.csv.Flush()
+		if err := rw.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return rw.writer.Close()
+}
@@ -0,0 +1,173 @@
+package gocommons
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordTestRow struct {
+	Name    string    `csv:"name" json:"name"`
+	Count   int       `csv:"count" json:"count"`
+	Ratio   float64   `csv:"ratio" json:"ratio"`
+	OK      bool      `csv:"ok" json:"ok"`
+	When    time.Time `csv:"when" json:"when"`
+	Ignored string    `csv:"-" json:"-"`
+	hidden  int
+}
+
+var recordTestRows = []recordTestRow{
+	{Name: "plain", Count: 1, Ratio: 0.5, OK: true, When: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+	{Name: "with, comma and \"quotes\"", Count: -2, Ratio: 1e10, When: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)},
+	{Name: "", Count: 0, Ratio: 0, When: time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)},
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	for _, format := range []RecordFormat{CSV, TSV, JSONL} {
+		for _, gz := range []FileType{GZ_FALSE, GZ_TRUE} {
+			path := "/tmp/record-test." + format.String() + gz.Suffix()
+			f, err := Open(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, gz)
+			require.Nil(err)
+			rw, err := f.RecordWriter(format, 0)
+			require.Nil(err)
+			for _, row := range recordTestRows {
+				row.Ignored = "dropped"
+				if format == TSV {
+					row.Name = strings.Replace(row.Name, ",", ";", -1)
+				}
+				require.Nil(rw.Write(&row))
+			}
+			require.Nil(rw.Close())
+			f.Close()
+
+			f, err = Open(path, os.O_RDONLY, GZ_UNKNOWN)
+			require.Nil(err)
+			rr, err := f.RecordReader(format)
+			require.Nil(err)
+			for _, expected := range recordTestRows {
+				if format == TSV {
+					expected.Name = strings.Replace(expected.Name, ",", ";", -1)
+				}
+				var row recordTestRow
+				require.Nil(rr.Read(&row), "%v", path)
+				assert.Equal(expected, row, "%v", path)
+			}
+			var row recordTestRow
+			assert.Equal(io.EOF, rr.Read(&row))
+			f.Close()
+			os.Remove(path)
+		}
+	}
+}
+
+func TestRecordReaderHeader(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := "/tmp/record-header-test.csv"
+	defer os.Remove(path)
+	// Columns in a different order, an unknown column and a missing one
+	require.Nil(os.WriteFile(path, []byte("extra,count,name\nx,3,\"multi\nline\"\ny,4,b\n"), 0664))
+	f, err := Open(path, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	defer f.Close()
+	rr, err := f.RecordReader(CSV)
+	require.Nil(err)
+	header, err := rr.Header()
+	require.Nil(err)
+	assert.Equal([]string{"extra", "count", "name"}, header)
+
+	var row recordTestRow
+	require.Nil(rr.Read(&row))
+	assert.Equal(recordTestRow{Name: "multi\nline", Count: 3}, row)
+	row = recordTestRow{}
+	require.Nil(rr.Read(&row))
+	assert.Equal(recordTestRow{Name: "b", Count: 4}, row)
+
+	assert.NotNil(rr.Read(row), "Should need a pointer")
+}
+
+func TestRecordReaderErrors(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	readErr := func(name string, contents string, format RecordFormat) *RecordError {
+		path := "/tmp/record-errors-test-" + name
+		defer os.Remove(path)
+		require.Nil(os.WriteFile(path, []byte(contents), 0664))
+		f, err := Open(path, os.O_RDONLY, GZ_UNKNOWN)
+		require.Nil(err)
+		defer f.Close()
+		rr, err := f.RecordReader(format)
+		require.Nil(err)
+		for {
+			var row recordTestRow
+			if err = rr.Read(&row); err != nil {
+				break
+			}
+		}
+		var recordErr *RecordError
+		require.True(errors.As(err, &recordErr), "Expected a RecordError for %v: %v", name, err)
+		assert.Equal(path, recordErr.Path)
+		return recordErr
+	}
+
+	// Bad value
+	err := readErr("value.csv", "name,count\na,1\n\"b\nb\",x\n", CSV)
+	assert.Equal(RecordError{err.Path, 3, 2, "count", err.Err}, *err)
+
+	// Bad quoting
+	err = readErr("quote.csv", "name,count\na,1\nb\"c,2\n", CSV)
+	assert.Equal(3, err.Line)
+	assert.True(err.Column > 0)
+
+	// Wrong number of fields
+	err = readErr("fields.tsv", "name\tcount\na\t1\n\nb\n", TSV)
+	assert.Equal(4, err.Line)
+
+	// Bad type in JSON
+	err = readErr("type.jsonl", "{\"name\": \"a\"}\n\n{\"name\": \"b\", \"count\": \"x\"}\n", JSONL)
+	assert.Equal(3, err.Line)
+	assert.Equal("count", err.Field)
+	assert.True(err.Column > 0)
+
+	// Bad syntax in JSON
+	err = readErr("syntax.jsonl", "{\"name\": \"a\"}\n{\"name\": \n", JSONL)
+	assert.Equal(2, err.Line)
+}
+
+func TestRecordWriterErrors(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := "/tmp/record-writer-errors.tsv"
+	defer os.Remove(path)
+	f, err := Open(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, GZ_FALSE)
+	require.Nil(err)
+	defer f.Close()
+	rw, err := f.RecordWriter(TSV, 0)
+	require.Nil(err)
+	err = rw.Write(recordTestRow{Name: "a\tb"})
+	var recordErr *RecordError
+	require.True(errors.As(err, &recordErr))
+	assert.Equal(2, recordErr.Line)
+	assert.Equal(1, recordErr.Column)
+	assert.NotNil(rw.Write(42), "Should need a struct")
+}
@@ -0,0 +1,169 @@
+package gocommons
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const DEFAULT_REVERSE_BLOCKSIZE = 64 * 1024
+
+// ReverseReader yields the lines of a file from the last to the first.
+// It is used like a bufio.Scanner.
+// Plain files are read backwards blockSize bytes at a time. Gzip files
+// are decompressed one access point span of a GzipIndex at a time, so
+// memory use is bounded by the span (plus the longest line) rather than
+// the size of the file.
+type ReverseReader struct {
+	// Returns the block preceding the last one returned, or io.EOF
+	prev func() ([]byte, error)
+	// Start of the line that continues into the block read last
+	carry []byte
+	// Lines of the current block, in file order
+	lines [][]byte
+	first bool
+	line  []byte
+	err   error
+}
+
+// ReverseReader returns a ReverseReader for f.
+// For gzip files, this builds a GzipIndex which means decompressing the
+// whole file once. Use ReverseReaderWithIndex to reuse an existing index.
+// A blockSize of 0 uses DEFAULT_REVERSE_BLOCKSIZE for plain files and
+// DEFAULT_GZ_INDEX_SPAN for gzip files.
+func (f *File) ReverseReader(blockSize int) (*ReverseReader, error) {
+	switch f.gz {
+	case GZ_FALSE:
+		return f.plainReverseReader(blockSize)
+	case GZ_TRUE:
+		idx, err := BuildGzipIndex(f, int64(blockSize))
+		if err != nil {
+			return nil, err
+		}
+		return f.ReverseReaderWithIndex(idx)
+	}
+	return nil, errors.New(fmt.Sprintf("Reading backwards is only supported for plain and gzip files: %v", f.Path))
+}
+
+func (f *File) plainReverseReader(blockSize int) (*ReverseReader, error) {
+	if blockSize <= 0 {
+		blockSize = DEFAULT_REVERSE_BLOCKSIZE
+	}
+	stat, err := f.handle.Stat()
+	if err != nil {
+		return nil, err
+	}
+	pos := stat.Size()
+	prev := func() ([]byte, error) {
+		if pos == 0 {
+			return nil, io.EOF
+		}
+		size := int64(blockSize)
+		if size > pos {
+			size = pos
+		}
+		pos -= size
+		block := make([]byte, size)
+		if _, err := f.handle.ReadAt(block, pos); err != nil && err != io.EOF {
+			return nil, err
+		}
+		return block, nil
+	}
+	return &ReverseReader{prev: prev, first: true}, nil
+}
+
+// ReverseReaderWithIndex returns a ReverseReader for the gzip file f
+// using idx to jump between access points
+func (f *File) ReverseReaderWithIndex(idx *GzipIndex) (*ReverseReader, error) {
+	if err := idx.check(f); err != nil {
+		return nil, err
+	}
+	next := len(idx.Points) - 1
+	prev := func() ([]byte, error) {
+		if next < 0 {
+			return nil, io.EOF
+		}
+		point := &idx.Points[next]
+		end := idx.Size
+		if next+1 < len(idx.Points) {
+			end = idx.Points[next+1].Out
+		}
+		next--
+		reader, err := f.readFromPoint(idx, point)
+		if err != nil {
+			return nil, err
+		}
+		block := make([]byte, end-point.Out)
+		if _, err = io.ReadFull(reader, block); err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to read '%v' at offset %d: %v", f.Path, point.Out, err))
+		}
+		return block, nil
+	}
+	return &ReverseReader{prev: prev, first: true}, nil
+}
+
+// Scan advances to the previous line
+func (r *ReverseReader) Scan() bool {
+	for len(r.lines) == 0 {
+		if r.err != nil {
+			return false
+		}
+		r.load()
+	}
+	last := len(r.lines) - 1
+	r.line = bytes.TrimSuffix(r.lines[last], []byte{'\r'})
+	r.lines = r.lines[:last]
+	return true
+}
+
+// load reads the previous block and splits it into lines
+func (r *ReverseReader) load() {
+	block, err := r.prev()
+	if err == io.EOF {
+		// The very first line of the file
+		r.err = io.EOF
+		if len(r.carry) > 0 || !r.first {
+			r.lines = [][]byte{r.carry}
+		}
+		r.carry = nil
+		return
+	}
+	if err != nil {
+		r.err = err
+		return
+	}
+
+	data := append(block, r.carry...)
+	nl := bytes.IndexByte(data, '\n')
+	if nl < 0 {
+		// Still in the middle of a line
+		r.carry = data
+		return
+	}
+	r.carry = append([]byte(nil), data[:nl]...)
+	r.lines = bytes.Split(data[nl+1:], []byte{'\n'})
+	if r.first {
+		// A trailing newline ends the last line rather than starting a new one
+		if last := len(r.lines) - 1; len(r.lines[last]) == 0 {
+			r.lines = r.lines[:last]
+		}
+		r.first = false
+	}
+}
+
+func (r *ReverseReader) Text() string {
+	return string(r.line)
+}
+
+func (r *ReverseReader) Bytes() []byte {
+	return r.line
+}
+
+// Err returns the first error other than io.EOF
+func (r *ReverseReader) Err() error {
+	if r.err == io.EOF {
+		return nil
+	}
+	return r.err
+}
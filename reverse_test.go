@@ -0,0 +1,97 @@
+package gocommons
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func reverseLines(t *testing.T, f *File, blockSize int) (lines []string) {
+	r, err := f.ReverseReader(blockSize)
+	require.Nil(t, err)
+	for r.Scan() {
+		lines = append(lines, r.Text())
+	}
+	require.Nil(t, r.Err())
+	return
+}
+
+func expectedReverseLines(contents string) (lines []string) {
+	if contents == "" {
+		return nil
+	}
+	forward := strings.Split(strings.TrimSuffix(contents, "\n"), "\n")
+	for idx := len(forward) - 1; idx >= 0; idx-- {
+		lines = append(lines, strings.TrimSuffix(forward[idx], "\r"))
+	}
+	return
+}
+
+func TestReverseReaderPlain(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := "/tmp/reverse-reader-test.txt"
+	defer os.Remove(path)
+	for _, contents := range []string{
+		"",
+		"\n",
+		"\n\n",
+		"a",
+		"a\n",
+		"a\nb",
+		"a\n\nb\n",
+		"first\r\nsecond\r\n",
+		"a long line\nshort\n\n\nanother long line without newline",
+	} {
+		require.Nil(os.WriteFile(path, []byte(contents), 0664))
+		f, err := Open(path, os.O_RDONLY, GZ_FALSE)
+		require.Nil(err)
+		for _, blockSize := range []int{1, 2, 3, 7, 0} {
+			assert.Equal(expectedReverseLines(contents), reverseLines(t, f, blockSize), "%q with block size %d", contents, blockSize)
+		}
+		f.Close()
+	}
+}
+
+func TestReverseReaderGzip(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data := gzipTestData(30000)
+	parts := [][]byte{data[:len(data)/3], data[len(data)/3:]}
+	path := "/tmp/reverse-reader-test.gz"
+	writeGzipTestFile(t, path, parts...)
+	defer os.Remove(path)
+
+	f, err := Open(path, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	defer f.Close()
+
+	expected := expectedReverseLines(string(data))
+	got := reverseLines(t, f, 32*1024)
+	require.Equal(len(expected), len(got))
+	assert.Equal(expected, got)
+
+	// With an index that is reused
+	idx, err := BuildGzipIndex(f, 100*1024)
+	require.Nil(err)
+	r, err := f.ReverseReaderWithIndex(idx)
+	require.Nil(err)
+	for i := 0; i < 10 && r.Scan(); i++ {
+		assert.Equal(expected[i], r.Text())
+	}
+
+	xz, err := Open("./test_files/open-test.xz", os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	defer xz.Close()
+	_, err = xz.ReverseReader(0)
+	assert.NotNil(err, "Only plain and gzip files can be read backwards")
+}
@@ -0,0 +1,279 @@
+package gocommons
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// RotateParams control when a RotatingWriter rolls over to a new file.
+// A zero value for any of the limits disables it.
+type RotateParams struct {
+	MaxBytes int64
+	MaxLines int64
+	Interval time.Duration
+	// Number of rotated segments to keep. 0 keeps all of them
+	MaxSegments int
+	// Gzip rotated segments in the background.
+	// This has no effect if the file is already compressed.
+	Compress bool
+	// FileType of the live file. GZ_UNKNOWN picks it from the suffix.
+	FileType FileType
+	// Size of the buffer used by the underlying Writer
+	Bufsize int
+}
+
+// RotatingWriter writes to path and moves it aside when it grows too
+// large, has too many lines or becomes too old.
+// Rotated segments are named path.1, path.2, ... with path.1 being the
+// most recent. Compressed segments get an additional .gz suffix.
+// Limits are checked before every Write and a single Write is never
+// split across segments.
+type RotatingWriter struct {
+	mutex  sync.Mutex
+	Path   string
+	params RotateParams
+
+	file   *File
+	writer Writer
+	bytes  int64
+	lines  int64
+	opened time.Time
+
+	// Background compressions
+	wg       sync.WaitGroup
+	errmu    sync.Mutex
+	asyncErr error
+	compress func(src string, dst string) error
+	// Set when the live file could not be reopened after a failed rotation
+	broken error
+}
+
+// NewRotatingWriter opens path for appending.
+// Bytes already in the file count towards MaxBytes if it is uncompressed.
+func NewRotatingWriter(path string, params RotateParams) (*RotatingWriter, error) {
+	if params.FileType == GZ_UNKNOWN {
+		var ok bool
+		if params.FileType, ok = FileTypeFromPath(path); !ok {
+			params.FileType = GZ_FALSE
+		}
+	}
+	rw := &RotatingWriter{Path: path, params: params, compress: compressSegment}
+	if err := rw.reopen(); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+// reopen opens the live file for appending
+func (rw *RotatingWriter) reopen() error {
+	if err := rw.open(os.O_WRONLY | os.O_CREATE | os.O_APPEND); err != nil {
+		return err
+	}
+	if rw.params.FileType == GZ_FALSE {
+		if stat, err := rw.file.handle.Stat(); err == nil {
+			rw.bytes = stat.Size()
+		}
+	}
+	return nil
+}
+
+func (rw *RotatingWriter) open(mode int) (err error) {
+	if rw.file, err = Open(rw.Path, mode, rw.params.FileType); err != nil {
+		return
+	}
+	if rw.writer, err = rw.file.Writer(rw.params.Bufsize); err != nil {
+		rw.file.Close()
+		return
+	}
+	rw.bytes = 0
+	rw.lines = 0
+	rw.opened = time.Now()
+	return
+}
+
+func (rw *RotatingWriter) close() error {
+	err := rw.writer.Close()
+	rw.file.Close()
+	return err
+}
+
+func (rw *RotatingWriter) shouldRotate(b []byte) bool {
+	p := &rw.params
+	if p.MaxBytes > 0 && rw.bytes > 0 && rw.bytes+int64(len(b)) > p.MaxBytes {
+		return true
+	}
+	if p.MaxLines > 0 && rw.lines >= p.MaxLines {
+		return true
+	}
+	if p.Interval > 0 && time.Since(rw.opened) >= p.Interval {
+		return true
+	}
+	return false
+}
+
+func (rw *RotatingWriter) Write(b []byte) (n int, err error) {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	if rw.broken != nil {
+		return 0, rw.broken
+	}
+	if rw.shouldRotate(b) {
+		if err = rw.rotate(); err != nil {
+			return
+		}
+	}
+	n, err = rw.writer.Write(b)
+	rw.bytes += int64(n)
+	rw.lines += int64(bytes.Count(b[:n], []byte{'\n'}))
+	return
+}
+
+func (rw *RotatingWriter) Flush() error {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+	if rw.broken != nil {
+		return rw.broken
+	}
+	return rw.writer.Flush()
+}
+
+// Rotate moves the current file aside and starts a new one
+func (rw *RotatingWriter) Rotate() error {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+	return rw.rotate()
+}
+
+// Close closes the live file and waits for background compressions.
+// It returns the first error encountered by a background compression.
+func (rw *RotatingWriter) Close() error {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+	err := rw.broken
+	if err == nil {
+		err = rw.close()
+	}
+	rw.wg.Wait()
+	if asyncErr := rw.takeAsyncErr(); asyncErr != nil {
+		return asyncErr
+	}
+	return err
+}
+
+// Segment returns the path of the nth rotated segment
+func (rw *RotatingWriter) Segment(n int) string {
+	path := fmt.Sprintf("%v.%d", rw.Path, n)
+	if rw.compresses() {
+		path += GZ_TRUE.Suffix()
+	}
+	return path
+}
+
+func (rw *RotatingWriter) compresses() bool {
+	return rw.params.Compress && rw.params.FileType == GZ_FALSE
+}
+
+func (rw *RotatingWriter) takeAsyncErr() error {
+	rw.errmu.Lock()
+	defer rw.errmu.Unlock()
+	err := rw.asyncErr
+	rw.asyncErr = nil
+	return err
+}
+
+func (rw *RotatingWriter) rotate() (err error) {
+	// Segments are renamed below. Don't pull them out from under a compression.
+	rw.wg.Wait()
+	// If a compression failed, its input is still around as path.1 and must
+	// not be overwritten. Try again and keep writing to the live file
+	// until it succeeds.
+	segment := fmt.Sprintf("%v.%d", rw.Path, 1)
+	if rw.compresses() {
+		if exists, _ := Exists(segment); exists {
+			if err = rw.compress(segment, rw.Segment(1)); err != nil {
+				return err
+			}
+			rw.takeAsyncErr()
+		}
+	}
+
+	// Shift every segment up by one, dropping the ones that are too old
+	last := 0
+	for {
+		if exists, _ := Exists(rw.Segment(last + 1)); !exists {
+			break
+		}
+		last++
+	}
+	for n := last; n > 0; n-- {
+		if rw.params.MaxSegments > 0 && n >= rw.params.MaxSegments {
+			if err = os.Remove(rw.Segment(n)); err != nil {
+				return
+			}
+			continue
+		}
+		if err = os.Rename(rw.Segment(n), rw.Segment(n+1)); err != nil {
+			return
+		}
+	}
+	if exists, _ := Exists(segment); exists {
+		return errors.New(fmt.Sprintf("Refusing to overwrite '%v'", segment))
+	}
+
+	// From here on, the live file has to be reopened if anything goes wrong
+	defer func() {
+		if err != nil && rw.reopen() != nil {
+			rw.broken = errors.New(fmt.Sprintf("Failed to reopen '%v' after failed rotation: %v", rw.Path, err))
+		}
+	}()
+	if err = rw.close(); err != nil {
+		return
+	}
+	if err = os.Rename(rw.Path, segment); err != nil {
+		return
+	}
+	if rw.compresses() {
+		rw.wg.Add(1)
+		go func() {
+			defer rw.wg.Done()
+			if err := rw.compress(segment, rw.Segment(1)); err != nil {
+				rw.errmu.Lock()
+				rw.asyncErr = err
+				rw.errmu.Unlock()
+			}
+		}()
+	}
+	return rw.open(os.O_WRONLY | os.O_CREATE | os.O_TRUNC)
+}
+
+// compressSegment gzips src into dst and removes src
+func compressSegment(src string, dst string) (err error) {
+	var in *File
+	var out *AtomicFile
+	var writer Writer
+
+	if in, err = Open(src, os.O_RDONLY, GZ_FALSE); err != nil {
+		return
+	}
+	defer in.Close()
+	if out, err = OpenAtomic(dst, GZ_TRUE); err != nil {
+		return
+	}
+	defer out.Close()
+	if writer, err = out.Writer(0); err != nil {
+		return
+	}
+	if _, err = io.Copy(writer, in.handle); err != nil {
+		return errors.New(fmt.Sprintf("Failed to compress '%v': %v", src, err))
+	}
+	if err = out.Commit(); err != nil {
+		return
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,203 @@
+package gocommons
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAllLines(t *testing.T, path string) []string {
+	f, err := Open(path, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(t, err, "Failed to open '%v'", path)
+	defer f.Close()
+
+	channel := make(chan string)
+	go f.AsyncRead(bufio.ScanLines, channel)
+	lines := make([]string, 0)
+	for line := range channel {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestRotatingWriterLines(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := "/tmp/rotate-lines"
+	os.RemoveAll(dir)
+	require.Nil(Makedirs(dir))
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.txt")
+	rw, err := NewRotatingWriter(path, RotateParams{MaxLines: 10, MaxSegments: 3})
+	require.Nil(err)
+	for i := 0; i < 45; i++ {
+		_, err = rw.Write([]byte(fmt.Sprintf("%d\n", i)))
+		require.Nil(err)
+	}
+	require.Nil(rw.Close())
+
+	files, _ := ListFiles(dir, []string{"*"})
+	assert.Equal([]string{path, path + ".1", path + ".2", path + ".3"}, files)
+
+	// Most recent segment first
+	expected := map[string][]string{path: {}, path + ".1": {}, path + ".2": {}, path + ".3": {}}
+	for i := 40; i < 45; i++ {
+		expected[path] = append(expected[path], fmt.Sprintf("%d", i))
+	}
+	for seg := 1; seg <= 3; seg++ {
+		key := rw.Segment(seg)
+		for i := 40 - seg*10; i < 50-seg*10; i++ {
+			expected[key] = append(expected[key], fmt.Sprintf("%d", i))
+		}
+	}
+	for file, lines := range expected {
+		assert.Equal(lines, readAllLines(t, file), fmt.Sprintf("Mismatch in %v", file))
+	}
+}
+
+func TestRotatingWriterBytesCompress(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := "/tmp/rotate-bytes"
+	os.RemoveAll(dir)
+	require.Nil(Makedirs(dir))
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.txt")
+	// Pre-existing data counts towards the limit
+	f, err := Open(path, os.O_WRONLY|os.O_CREATE, GZ_FALSE)
+	require.Nil(err)
+	f.File.Write([]byte("0123456789\n"))
+	f.Close()
+
+	rw, err := NewRotatingWriter(path, RotateParams{MaxBytes: 25, Compress: true, Bufsize: 4096})
+	require.Nil(err)
+	for i := 0; i < 5; i++ {
+		// Each line is 11 bytes. Two lines fit in a segment
+		_, err = rw.Write([]byte(fmt.Sprintf("line-%05d\n", i)))
+		require.Nil(err)
+	}
+	require.Nil(rw.Close())
+
+	files, _ := ListFiles(dir, []string{"*"})
+	assert.Equal([]string{path, path + ".1.gz", path + ".2.gz"}, files)
+	assert.Equal([]string{"line-00003", "line-00004"}, readAllLines(t, path))
+	assert.Equal([]string{"line-00001", "line-00002"}, readAllLines(t, path+".1.gz"))
+	assert.Equal([]string{"0123456789", "line-00000"}, readAllLines(t, path+".2.gz"))
+}
+
+func TestRotatingWriterInterval(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := "/tmp/rotate-interval"
+	os.RemoveAll(dir)
+	require.Nil(Makedirs(dir))
+	defer os.RemoveAll(dir)
+
+	// Already compressed files are rotated as is
+	path := filepath.Join(dir, "a.gz")
+	rw, err := NewRotatingWriter(path, RotateParams{Interval: 50 * time.Millisecond, Compress: true, FileType: GZ_UNKNOWN})
+	require.Nil(err)
+	rw.Write([]byte("first\n"))
+	time.Sleep(100 * time.Millisecond)
+	rw.Write([]byte("second\n"))
+	require.Nil(rw.Close())
+
+	assert.Equal(path+".1", rw.Segment(1))
+	assert.Equal([]string{"first"}, readAllLines(t, path+".1"))
+	assert.Equal([]string{"second"}, readAllLines(t, path))
+}
+
+func TestRotatingWriterCompressFailure(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := "/tmp/rotate-compress-failure"
+	os.RemoveAll(dir)
+	require.Nil(Makedirs(dir))
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.txt")
+	rw, err := NewRotatingWriter(path, RotateParams{MaxLines: 1, Compress: true})
+	require.Nil(err)
+	failing := true
+	rw.compress = func(src string, dst string) error {
+		if failing {
+			return errors.New("Compression failed")
+		}
+		return compressSegment(src, dst)
+	}
+
+	_, err = rw.Write([]byte("0\n"))
+	require.Nil(err)
+	// Rotates and fails to compress in the background
+	_, err = rw.Write([]byte("1\n"))
+	require.Nil(err)
+	// Every rotation fails until the segment has been compressed
+	for i := 0; i < 3; i++ {
+		_, err = rw.Write([]byte("2\n"))
+		assert.NotNil(err, "Should have failed to rotate")
+		require.Nil(rw.Flush())
+		assert.Equal([]string{"0"}, readAllLines(t, path+".1"))
+		assert.Equal([]string{"1"}, readAllLines(t, path))
+	}
+
+	failing = false
+	_, err = rw.Write([]byte("2\n"))
+	require.Nil(err)
+	require.Nil(rw.Close())
+
+	files, _ := ListFiles(dir, []string{"*"})
+	assert.Equal([]string{path, path + ".1.gz", path + ".2.gz"}, files)
+	assert.Equal([]string{"2"}, readAllLines(t, path))
+	assert.Equal([]string{"1"}, readAllLines(t, path+".1.gz"))
+	assert.Equal([]string{"0"}, readAllLines(t, path+".2.gz"))
+}
+
+func TestRotatingWriterRenameFailure(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := "/tmp/rotate-rename-failure"
+	os.RemoveAll(dir)
+	require.Nil(Makedirs(dir))
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.txt")
+	rw, err := NewRotatingWriter(path, RotateParams{MaxLines: 1})
+	require.Nil(err)
+	_, err = rw.Write([]byte("0\n"))
+	require.Nil(err)
+
+	// The live file can't be moved aside if it is gone
+	require.Nil(os.Remove(path))
+	_, err = rw.Write([]byte("1\n"))
+	assert.NotNil(err, "Should have failed to rotate")
+
+	// The writer is still usable
+	_, err = rw.Write([]byte("1\n"))
+	require.Nil(err)
+	require.Nil(rw.Close())
+	assert.Equal([]string{"1"}, readAllLines(t, path))
+}
@@ -0,0 +1,213 @@
+package gocommons
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// Shard is a byte range of a file that starts at the beginning of a line
+// and ends just after a newline or at the end of the file.
+// Shards of a file cover all of it without overlapping, so each line
+// belongs to exactly one shard.
+type Shard struct {
+	Path  string
+	Index int
+	// Offsets in the file. For gzip files these are compressed offsets
+	Start int64
+	End   int64
+	gz    FileType
+	fs    FS
+}
+
+// Shards splits f into at most n shards of roughly equal size that can
+// be read independently, e.g. by one worker each.
+//
+// Plain files are cut at the first newline at or after every n-th of the
+// file. A shard may therefore be larger than size/n when lines are long
+// and there may be fewer than n shards.
+//
+// Gzip files are cut between members only, since a member can only be
+// decompressed from its start. Finding the members means decompressing
+// the file once. A file written as a single member, which is the usual
+// case, yields a single shard. This includes files written by
+// ParallelWriter. Files made by concatenating gzip files have one member
+// per file.
+//
+// Other compressed files always yield a single shard.
+func (f *File) Shards(n int) ([]Shard, error) {
+	if n <= 0 {
+		return nil, errors.New(fmt.Sprintf("Invalid number of shards: %d", n))
+	}
+	stat, err := f.handle.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := stat.Size()
+
+	var cuts []int64
+	switch {
+	case size == 0:
+		cuts = []int64{0, 0}
+	case f.gz == GZ_FALSE:
+		cuts, err = f.lineCuts(size, n)
+	case f.gz == GZ_TRUE:
+		cuts, err = f.memberCuts(size, n)
+	default:
+		cuts = []int64{0, size}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	shards := make([]Shard, 0, len(cuts)-1)
+	for idx := 0; idx+1 < len(cuts); idx++ {
+		if cuts[idx] == cuts[idx+1] {
+			continue
+		}
+		shards = append(shards, Shard{f.Path, len(shards), cuts[idx], cuts[idx+1], f.gz, f.fs})
+	}
+	if len(shards) == 0 {
+		// An empty file still gets a shard
+		shards = append(shards, Shard{f.Path, 0, 0, size, f.gz, f.fs})
+	}
+	return shards, nil
+}
+
+// lineCuts returns the offsets at which to cut a plain file.
+// Empty ranges are dropped by Shards.
+func (f *File) lineCuts(size int64, n int) ([]int64, error) {
+	cuts := []int64{0}
+	buf := make([]byte, 64*1024)
+	for idx := 1; idx < n; idx++ {
+		target := size * int64(idx) / int64(n)
+		if prev := cuts[len(cuts)-1]; target < prev {
+			target = prev
+		}
+		cut, err := f.nextLineStart(target, size, buf)
+		if err != nil {
+			return nil, err
+		}
+		cuts = append(cuts, cut)
+	}
+	return append(cuts, size), nil
+}
+
+// nextLineStart returns the offset of the first line starting at or after offset
+func (f *File) nextLineStart(offset int64, size int64, buf []byte) (int64, error) {
+	if offset == 0 {
+		return 0, nil
+	}
+	// If the previous byte is a newline, offset already starts a line
+	pos := offset - 1
+	for pos < size {
+		n, err := f.handle.ReadAt(buf, pos)
+		for idx := 0; idx < n; idx++ {
+			if buf[idx] == '\n' {
+				return pos + int64(idx) + 1, nil
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		pos += int64(n)
+	}
+	return size, nil
+}
+
+// memberCuts returns the offsets at which to cut a gzip file
+func (f *File) memberCuts(size int64, n int) ([]int64, error) {
+	var ends []int64
+	in := newInflater(io.NewSectionReader(f.handle, 0, size))
+	in.onMember = func() {
+		ends = append(ends, in.bitPos()/8)
+	}
+	if _, err := io.Copy(io.Discard, in); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to read '%v': %v", f.Path, err))
+	}
+
+	if len(ends) == 0 {
+		return []int64{0, size}, nil
+	}
+	// The last member ends at the end of the file
+	ends = ends[:len(ends)-1]
+	if len(ends) < n {
+		return append(append([]int64{0}, ends...), size), nil
+	}
+	// Cut at the member end closest to every n-th of the file
+	cuts := []int64{0}
+	next := 0
+	for idx := 1; idx < n; idx++ {
+		target := size * int64(idx) / int64(n)
+		for next+1 < len(ends) && ends[next+1] <= target {
+			next++
+		}
+		cut := ends[next]
+		if next+1 < len(ends) && ends[next+1]-target < target-cut {
+			cut = ends[next+1]
+		}
+		if cut > cuts[len(cuts)-1] {
+			cuts = append(cuts, cut)
+		}
+	}
+	return append(cuts, size), nil
+}
+
+// Open opens the shard on its own. The returned File only sees the
+// shard's bytes, so its Reader, AsyncRead and friends read just the
+// shard's lines. It is read-only.
+func (s *Shard) Open() (*File, error) {
+	f, err := OpenFS(s.fs, s.Path, os.O_RDONLY, s.gz)
+	if err != nil {
+		return nil, err
+	}
+	f.handle = &shardHandle{f.handle, io.NewSectionReader(f.handle, s.Start, s.End-s.Start), s}
+	f.File = nil
+	return f, nil
+}
+
+// shardHandle limits a FileHandle to the range of a Shard
+type shardHandle struct {
+	FileHandle
+	section *io.SectionReader
+	shard   *Shard
+}
+
+func (h *shardHandle) Read(b []byte) (int, error) {
+	return h.section.Read(b)
+}
+
+func (h *shardHandle) ReadAt(b []byte, offset int64) (int, error) {
+	return h.section.ReadAt(b, offset)
+}
+
+func (h *shardHandle) Seek(offset int64, whence int) (int64, error) {
+	return h.section.Seek(offset, whence)
+}
+
+func (h *shardHandle) Write(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: h.shard.Path, Err: fs.ErrPermission}
+}
+
+func (h *shardHandle) Stat() (fs.FileInfo, error) {
+	info, err := h.FileHandle.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return shardInfo{info, h.section.Size()}, nil
+}
+
+// shardInfo reports the size of the shard rather than the file
+type shardInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (si shardInfo) Size() int64 {
+	return si.size
+}
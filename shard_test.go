@@ -0,0 +1,168 @@
+package gocommons
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func shardLines(t *testing.T, shards []Shard) (lines []string) {
+	for idx := range shards {
+		f, err := shards[idx].Open()
+		require.Nil(t, err)
+		channel := make(chan string)
+		go f.AsyncRead(bufio.ScanLines, channel)
+		for line := range channel {
+			lines = append(lines, line)
+		}
+		f.Close()
+	}
+	return
+}
+
+func TestShardsPlain(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data := gzipTestData(10000)
+	expected := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	path := "/tmp/shards-test.txt"
+	require.Nil(os.WriteFile(path, data, 0664))
+	defer os.Remove(path)
+
+	f, err := Open(path, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	defer f.Close()
+	for _, n := range []int{1, 2, 3, 7, 16} {
+		shards, err := f.Shards(n)
+		require.Nil(err)
+		assert.Equal(n, len(shards))
+		assert.Equal(int64(0), shards[0].Start)
+		assert.Equal(int64(len(data)), shards[len(shards)-1].End)
+		for idx, shard := range shards {
+			assert.Equal(idx, shard.Index)
+			if idx > 0 {
+				assert.Equal(shards[idx-1].End, shard.Start)
+				assert.Equal(byte('\n'), data[shard.Start-1], "Shard %d does not start a line", idx)
+			}
+		}
+		assert.Equal(expected, shardLines(t, shards), "Mismatch with %d shards", n)
+	}
+
+	// Each shard reports its own size
+	shards, _ := f.Shards(4)
+	sf, err := shards[1].Open()
+	require.Nil(err)
+	stat, err := sf.Handle().Stat()
+	require.Nil(err)
+	assert.Equal(shards[1].End-shards[1].Start, stat.Size())
+	_, err = sf.Handle().Write([]byte("x"))
+	assert.NotNil(err, "Shards are read-only")
+	sf.Close()
+
+	_, err = f.Shards(0)
+	assert.NotNil(err)
+}
+
+func TestShardsPlainEdgeCases(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := "/tmp/shards-edge-test.txt"
+	defer os.Remove(path)
+	for _, contents := range []string{"", "one line without newline", "a\n", strings.Repeat("x", 1000) + "\nb\nc\n"} {
+		require.Nil(os.WriteFile(path, []byte(contents), 0664))
+		f, err := Open(path, os.O_RDONLY, GZ_FALSE)
+		require.Nil(err)
+		shards, err := f.Shards(4)
+		require.Nil(err)
+		assert.True(len(shards) >= 1 && len(shards) <= 4)
+		var expected []string
+		if contents != "" {
+			expected = strings.Split(strings.TrimSuffix(contents, "\n"), "\n")
+		}
+		assert.Equal(expected, shardLines(t, shards), "%q", contents)
+		f.Close()
+	}
+}
+
+func TestShardsGzip(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data := gzipTestData(20000)
+	expected := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	// Members split at line boundaries
+	var members [][]byte
+	for idx := 0; idx < 6; idx++ {
+		start := strings.Index(string(data), expected[idx*len(expected)/6])
+		end := len(data)
+		if idx < 5 {
+			end = strings.Index(string(data), expected[(idx+1)*len(expected)/6])
+		}
+		members = append(members, data[start:end])
+	}
+	path := "/tmp/shards-test.gz"
+	writeGzipTestFile(t, path, members...)
+	defer os.Remove(path)
+
+	f, err := Open(path, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	defer f.Close()
+	for _, n := range []int{1, 2, 3, 6, 10} {
+		shards, err := f.Shards(n)
+		require.Nil(err)
+		// Members are of very different sizes so there may be fewer shards
+		if n >= 6 {
+			assert.Equal(6, len(shards), "Expected a shard per member")
+		} else {
+			assert.True(len(shards) >= 1 && len(shards) <= n, "Got %d shards for %d", len(shards), n)
+		}
+		for _, shard := range shards {
+			r, err := f.rawReaderFrom(io.NewSectionReader(f.Handle(), shard.Start, shard.End-shard.Start))
+			require.Nil(err)
+			_, err = io.Copy(io.Discard, r)
+			assert.Nil(err, "Shard should be a valid gzip stream")
+		}
+		assert.Equal(expected, shardLines(t, shards), "Mismatch with %d shards", n)
+	}
+
+	// A single member cannot be split
+	single := "/tmp/shards-single-test.gz"
+	writeGzipTestFile(t, single, data)
+	defer os.Remove(single)
+	sf, err := Open(single, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	defer sf.Close()
+	shards, err := sf.Shards(4)
+	require.Nil(err)
+	assert.Equal(1, len(shards))
+}
+
+func TestShardsEmptyGzip(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := "/tmp/shards-empty-test.gz"
+	require.Nil(os.WriteFile(path, nil, 0664))
+	defer os.Remove(path)
+	f, err := Open(path, os.O_RDONLY, GZ_UNKNOWN)
+	require.Nil(err)
+	defer f.Close()
+	shards, err := f.Shards(3)
+	assert.Nil(err)
+	assert.Equal(1, len(shards))
+}
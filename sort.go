@@ -2,13 +2,14 @@ package gocommons
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"sort"
-
-	"github.com/gurupras/go-easyfiles"
+	"sync/atomic"
+	"time"
 
 	"gopkg.in/vmihailenco/msgpack.v2"
 )
@@ -24,6 +25,9 @@ type SortParams struct {
 	Instance    func() SortInterface
 	LineConvert func(string) SortInterface
 	Lines       SortCollection
+	// Optional. Receives the progress of the split and merge phases
+	Progress         ProgressFunc
+	ProgressInterval time.Duration
 }
 
 func (s SortCollection) Len() int {
@@ -48,6 +52,13 @@ func (s SortCollection) Less(i, j int) bool {
 }
 
 func ExternalSort(file string, bufsize int, sort_params SortParams) (chunks []string, err error) {
+	return ExternalSortWithFileType(file, bufsize, sort_params, GZ_TRUE)
+}
+
+// ExternalSortWithFileType behaves like ExternalSort but writes chunks
+// of the given FileType. The chunk suffix follows the FileType so that
+// NWayMergeGenerator can detect it when reading them back.
+func ExternalSortWithFileType(file string, bufsize int, sort_params SortParams, chunk_type FileType) (chunks []string, err error) {
 	var fstruct *File
 
 	var outfile_path string
@@ -64,10 +75,27 @@ func ExternalSort(file string, bufsize int, sort_params SortParams) (chunks []st
 	}
 	defer fstruct.Close()
 
+	// Don't leave partial output behind
+	defer func() {
+		if err != nil {
+			for _, chunk := range chunks {
+				os.Remove(chunk)
+			}
+			chunks = nil
+		}
+	}()
+
 	inputChannel := make(chan string, 10000)
 	idx := 0
 	lines := 0
-	go fstruct.AsyncRead(bufio.ScanLines, inputChannel)
+	fstruct.SetProgress(sort_params.Progress, sort_params.ProgressInterval)
+	// Stops the reader if we return early
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- fstruct.asyncRead(ctx, bufio.ScanLines, 1048576, inputChannel, PROGRESS_SPLIT)
+	}()
 	for {
 		sort_params.Lines = sort_params.Lines[:0]
 		bytes_read = 0
@@ -81,7 +109,7 @@ func ExternalSort(file string, bufsize int, sort_params SortParams) (chunks []st
 				sort_params.Lines = append(sort_params.Lines, object)
 				bytes_read += len(line)
 			} else {
-				fmt.Fprintln(os.Stderr, fmt.Sprintf("Failed to parse line(%d): \n%s\n", line, idx))
+				fmt.Fprintf(os.Stderr, "Failed to parse line(%d): \n%s\n", idx, line)
 			}
 			lines++
 			if lines%1000 == 0 {
@@ -98,39 +126,43 @@ func ExternalSort(file string, bufsize int, sort_params SortParams) (chunks []st
 
 		sort.Sort(sort_params.Lines)
 
-		outfile_path = fmt.Sprintf("%s.chunk.%08d.gz", file, chunk_idx)
+		outfile_path = fmt.Sprintf("%s.chunk.%08d%s", file, chunk_idx, chunk_type.Suffix())
 		//fmt.Println("Saving to chunk:", outfile_path)
-		if outfile_raw, err = Open(outfile_path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, GZ_TRUE); err != nil {
+		if outfile_raw, err = Open(outfile_path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, chunk_type); err != nil {
 			return
 		}
 		defer outfile_raw.Close()
+		chunks = append(chunks, outfile_path)
 
 		if outfile, err = outfile_raw.Writer(0); err != nil {
 			return
 		}
-		chunks = append(chunks, outfile_path)
 
 		var b []byte
 		// First write the number of objects
 		if b, err = msgpack.Marshal(len(sort_params.Lines)); err != nil {
-			chunks = nil
 			return
 		}
 		if _, err = outfile.Write(b); err != nil {
-			chunks = nil
 			return
 		}
 
 		for _, object := range sort_params.Lines {
 			if b, err = msgpack.Marshal(object); err != nil {
-				chunks = nil
 				return
 			}
-			outfile.Write(b)
+			if _, err = outfile.Write(b); err != nil {
+				return
+			}
 		}
 		chunk_idx += 1
-		outfile.Flush()
-		outfile.Close()
+		if err = outfile.Close(); err != nil {
+			return
+		}
+	}
+	// A truncated input must not look like a short one
+	if err = <-readErr; err != nil {
+		return
 	}
 	//fmt.Fprintln(os.Stderr, fmt.Sprintf("%s: %d lines", file, lines))
 	return
@@ -143,9 +175,10 @@ func NWayMergeGenerator(chunks []string, sort_params SortParams, out_channel cha
 	var channels map[string]chan SortInterface = make(map[string]chan SortInterface)
 	var err error
 	quit := make(chan bool, 1)
+	var tracker *progressTracker
 
 	// Read file and write to channel
-	closed_channels := 0
+	var closed_channels int32
 	producer := func(idx int) {
 		chunk := chunks[idx]
 		reader := readers[chunk]
@@ -167,13 +200,14 @@ func NWayMergeGenerator(chunks []string, sort_params SortParams, out_channel cha
 			//fmt.Println("CHANNEL-%d: %s", idx, line)
 		}
 		//fmt.Println("Closing channel:", idx, ":", lines)
-		closed_channels++
+		atomic.AddInt32(&closed_channels, 1)
 		close(channel)
 	}
 
 	// Now for the consumer
 	consumer := func() {
 		defer close(out_channel)
+		defer tracker.finish()
 
 		loglines := make([]SortInterface, len(chunks))
 
@@ -218,7 +252,7 @@ func NWayMergeGenerator(chunks []string, sort_params SortParams, out_channel cha
 
 			if next_line == nil {
 				fmt.Fprintln(os.Stderr, "Attempting to write nil to file..The loop should've broken before this point")
-				fmt.Fprintln(os.Stderr, "Open channels:", (len(chunks) - closed_channels))
+				fmt.Fprintln(os.Stderr, "Open channels:", (len(chunks) - int(atomic.LoadInt32(&closed_channels))))
 				fmt.Fprintln(os.Stderr, "lines read:", lines_read)
 				for idx, l := range loglines {
 					if l != nil {
@@ -227,18 +261,29 @@ func NWayMergeGenerator(chunks []string, sort_params SortParams, out_channel cha
 				}
 				close(out_channel)
 			}
+			tracker.addLines(1)
 			out_channel <- next_line
 		}
 	}
 
 	// Set up readers and channels
-	for _, chunk := range chunks {
-		chunk_file, err := easyfiles.Open(chunk, os.O_RDONLY, easyfiles.GZ_TRUE)
+	chunk_files := make([]*File, len(chunks))
+	total_size := int64(0)
+	for idx, chunk := range chunks {
+		chunk_file, err := Open(chunk, os.O_RDONLY, GZ_UNKNOWN)
 		if err != nil {
 			goto out
 		}
 		defer chunk_file.Close()
-		reader, err := chunk_file.RawReader()
+		chunk_files[idx] = chunk_file
+		if stat, err := chunk_file.handle.Stat(); err == nil {
+			total_size += stat.Size()
+		}
+	}
+	tracker = newProgressTracker(PROGRESS_MERGE, total_size, sort_params.Progress, sort_params.ProgressInterval)
+	for idx, chunk := range chunks {
+		chunk_file := chunk_files[idx]
+		reader, err := chunk_file.rawReaderFrom(tracker.wrap(chunk_file.handle))
 		if err != nil {
 			goto out
 		}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"testing"
@@ -27,7 +28,7 @@ func (l *Int) Less(s SortInterface) (ret bool, err error) {
 	var ok bool
 	if s != nil {
 		if o, ok = s.(*Int); !ok {
-			err = errors.New(fmt.Sprintf("Failed to convert from SortInterface to *Int:", reflect.TypeOf(s)))
+			err = errors.New(fmt.Sprintf("Failed to convert from SortInterface to *Int: %v", reflect.TypeOf(s)))
 			ret = false
 			goto out
 		}
@@ -133,6 +134,67 @@ func TestIntSort(t *testing.T) {
 	}
 }
 
+func TestIntSortZstd(t *testing.T) {
+	t.Parallel()
+	testIntSortWithFileType(t, "/tmp/sort-zstd-input.txt", ZSTD)
+}
+
+func TestIntSortLz4(t *testing.T) {
+	t.Parallel()
+	testIntSortWithFileType(t, "/tmp/sort-lz4-input.txt", LZ4)
+}
+
+func testIntSortWithFileType(t *testing.T, input string, chunk_type FileType) {
+	assert := assert.New(t)
+
+	var err error
+	var f *File
+	var writer Writer
+	var chunks []string
+
+	if f, err = Open(input, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, GZ_FALSE); err != nil {
+		assert.Fail("Failed to create input file", err)
+		return
+	}
+	defer os.Remove(input)
+	writer, _ = f.Writer(0)
+	for i := 0; i < 10000; i++ {
+		writer.Write([]byte(fmt.Sprintf("%d\n", (i*7919)%10000)))
+	}
+	writer.Flush()
+	f.Close()
+
+	// Don't share the backing array of Lines with other tests
+	sort_params := IntSortParams
+	sort_params.Lines = make(SortCollection, 0)
+
+	if chunks, err = ExternalSortWithFileType(input, 4096, sort_params, chunk_type); err != nil {
+		assert.Fail("Failed to run external sort", err)
+		return
+	}
+	defer func() {
+		for _, chunk := range chunks {
+			os.Remove(chunk)
+		}
+	}()
+	assert.True(len(chunks) > 1, "Expected multiple chunks")
+	for _, chunk := range chunks {
+		assert.Equal(chunk_type.Suffix(), filepath.Ext(chunk), "Chunk has wrong suffix")
+	}
+
+	merge_out_channel := make(chan SortInterface, 10000)
+	callback := func(channel chan SortInterface, quit chan bool) {
+		expected := 0
+		for object := range channel {
+			assert.Equal(strconv.Itoa(expected), object.String())
+			expected++
+		}
+		assert.Equal(10000, expected, "Did not get back all lines")
+		quit <- true
+	}
+	NWayMergeGenerator(chunks, sort_params, merge_out_channel, callback)
+}
+
 type I interface {
 	String() string
 }
@@ -177,3 +239,40 @@ func TestMessagePackDecode(t *testing.T) {
 	require.True(reflect.DeepEqual(obj1, gotObj1))
 	require.True(reflect.DeepEqual(obj2, I(&gotObj2)), fmt.Sprintf("expected: %v\ngot: %v\n", obj2, gotObj2))
 }
+
+func TestExternalSortTruncated(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	input := "/tmp/sort-truncated-input.gz"
+	f, err := Open(input, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, GZ_TRUE)
+	require.Nil(err)
+	defer os.Remove(input)
+	writer, err := f.Writer(0)
+	require.Nil(err)
+	for i := 0; i < 100000; i++ {
+		writer.Write([]byte(fmt.Sprintf("%d\n", (i*7919)%100000)))
+	}
+	require.Nil(writer.Close())
+	f.Close()
+	stat, err := os.Stat(input)
+	require.Nil(err)
+	require.Nil(os.Truncate(input, stat.Size()/2))
+	defer func() {
+		chunks, _ := filepath.Glob(input + ".chunk.*")
+		for _, chunk := range chunks {
+			os.Remove(chunk)
+		}
+	}()
+
+	sort_params := IntSortParams
+	sort_params.Lines = make(SortCollection, 0)
+	chunks, err := ExternalSort(input, 4096, sort_params)
+	var readErr *ReadError
+	assert.True(errors.As(err, &readErr), "Expected a ReadError, got %v", err)
+	assert.Nil(chunks)
+	leftover, _ := filepath.Glob(input + ".chunk.*")
+	assert.Empty(leftover, "Chunks should have been removed")
+}
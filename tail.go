@@ -0,0 +1,220 @@
+package gocommons
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const DEFAULT_TAIL_POLL_INTERVAL = 250 * time.Millisecond
+
+// TailParams configure Tail
+type TailParams struct {
+	// Number of lines already in the file to start with.
+	// 0 only follows what is written from now on.
+	Lines int
+	// Start with every line already in the file. Overrides Lines
+	FromStart bool
+	// How often to look for new data. 0 uses DEFAULT_TAIL_POLL_INTERVAL
+	PollInterval time.Duration
+}
+
+// tailer follows a single path across rotations
+type tailer struct {
+	path    string
+	params  TailParams
+	channel chan<- string
+	file    *File
+	offset  int64
+	pending []byte
+	buf     []byte
+}
+
+// Tail works like `tail -F`. It sends the lines of the plain file at path
+// to channel as they are appended until ctx is done.
+// When path is truncated, Tail starts over from the beginning of the file.
+// When path is renamed and recreated, the rest of the old file is read
+// before moving on to the new one, so no lines are lost.
+// The channel is closed when Tail returns. Tail returns ctx.Err() once ctx
+// is done or the first error it could not recover from.
+func Tail(ctx context.Context, path string, params TailParams, channel chan<- string) error {
+	defer close(channel)
+	if params.PollInterval <= 0 {
+		params.PollInterval = DEFAULT_TAIL_POLL_INTERVAL
+	}
+	t := &tailer{path: path, params: params, channel: channel, buf: make([]byte, 64*1024)}
+	if err := t.open(ctx, true); err != nil {
+		return err
+	}
+	defer func() {
+		if t.file != nil {
+			t.file.Close()
+		}
+	}()
+
+	for {
+		n, err := t.read(ctx)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		// Caught up. See what happened to path in the meantime
+		if err = t.checkRotation(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(params.PollInterval):
+		}
+	}
+}
+
+// open opens path, waiting for it to appear. The first time around it
+// starts where params say. After a rotation it starts at the beginning.
+func (t *tailer) open(ctx context.Context, first bool) (err error) {
+	for {
+		if t.file, err = Open(t.path, os.O_RDONLY, GZ_FALSE); err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(t.params.PollInterval):
+		}
+	}
+
+	t.offset = 0
+	if first && !t.params.FromStart {
+		if t.offset, err = lastLinesOffset(t.file, t.params.Lines); err != nil {
+			t.file.Close()
+			return
+		}
+	}
+	_, err = t.file.Seek(t.offset, io.SeekStart)
+	return
+}
+
+// read reads whatever is available and sends every complete line
+func (t *tailer) read(ctx context.Context) (int, error) {
+	n, err := t.file.handle.Read(t.buf)
+	if err != nil && err != io.EOF {
+		return 0, errors.New(fmt.Sprintf("Failed to read '%v': %v", t.path, err))
+	}
+	t.offset += int64(n)
+	t.pending = append(t.pending, t.buf[:n]...)
+	for {
+		idx := bytes.IndexByte(t.pending, '\n')
+		if idx < 0 {
+			break
+		}
+		if err := t.send(ctx, t.pending[:idx]); err != nil {
+			return 0, err
+		}
+		t.pending = t.pending[idx+1:]
+	}
+	// Don't hold on to the backing array of everything read so far
+	t.pending = append([]byte(nil), t.pending...)
+	return n, nil
+}
+
+func (t *tailer) send(ctx context.Context, line []byte) error {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	select {
+	case t.channel <- string(line):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *tailer) checkRotation(ctx context.Context) error {
+	current, err := os.Stat(t.path)
+	if os.IsNotExist(err) {
+		// Moved away but not recreated yet. Keep reading the old file
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	opened, err := t.file.handle.Stat()
+	if err != nil {
+		return err
+	}
+
+	if os.SameFile(current, opened) {
+		if current.Size() < t.offset {
+			// Truncated. Whatever was pending is gone
+			t.pending = t.pending[:0]
+			t.offset = 0
+			_, err = t.file.Seek(0, io.SeekStart)
+		}
+		return err
+	}
+
+	// Rotated. Finish the old file before switching
+	for {
+		n, err := t.read(ctx)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+	}
+	if len(t.pending) > 0 {
+		if err = t.send(ctx, t.pending); err != nil {
+			return err
+		}
+		t.pending = t.pending[:0]
+	}
+	t.file.Close()
+	return t.open(ctx, false)
+}
+
+// lastLinesOffset returns the offset of the start of the last n lines of f
+func lastLinesOffset(f *File, n int) (int64, error) {
+	stat, err := f.handle.Stat()
+	if err != nil {
+		return 0, err
+	}
+	end := stat.Size()
+	if n <= 0 || end == 0 {
+		return end, nil
+	}
+
+	buf := make([]byte, 64*1024)
+	pos := end
+	for pos > 0 {
+		size := int64(len(buf))
+		if size > pos {
+			size = pos
+		}
+		pos -= size
+		if _, err := f.handle.ReadAt(buf[:size], pos); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for idx := size - 1; idx >= 0; idx-- {
+			if buf[idx] != '\n' {
+				continue
+			}
+			// A trailing newline ends the last line rather than starting a new one
+			if pos+idx == end-1 {
+				continue
+			}
+			if n--; n == 0 {
+				return pos + idx + 1, nil
+			}
+		}
+	}
+	return 0, nil
+}
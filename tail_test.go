@@ -0,0 +1,140 @@
+package gocommons
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendTestLines(t *testing.T, path string, lines ...string) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664)
+	require.Nil(t, err)
+	defer f.Close()
+	for _, line := range lines {
+		f.WriteString(line + "\n")
+	}
+}
+
+func receiveLines(t *testing.T, channel chan string, n int) (lines []string) {
+	for len(lines) < n {
+		select {
+		case line, ok := <-channel:
+			if !ok {
+				return
+			}
+			lines = append(lines, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for lines. Got %v", lines)
+		}
+	}
+	return
+}
+
+func TestLastLinesOffset(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := "/tmp/last-lines-offset-test.txt"
+	defer os.Remove(path)
+	var sb strings.Builder
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(&sb, "line-%d\n", i)
+	}
+	contents := sb.String()
+	require.Nil(os.WriteFile(path, []byte(contents), 0664))
+	f, err := Open(path, os.O_RDONLY, GZ_FALSE)
+	require.Nil(err)
+	defer f.Close()
+
+	offset, err := lastLinesOffset(f, 2)
+	assert.Nil(err)
+	assert.Equal("line-49998\nline-49999\n", contents[offset:])
+	offset, _ = lastLinesOffset(f, 0)
+	assert.Equal(int64(len(contents)), offset)
+	offset, _ = lastLinesOffset(f, 40000)
+	assert.Equal("line-10000\n", contents[offset:offset+11])
+	offset, _ = lastLinesOffset(f, 100000)
+	assert.Equal(int64(0), offset)
+}
+
+func TestTail(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	path := "/tmp/tail-test.txt"
+	os.Remove(path)
+	defer os.Remove(path)
+	defer os.Remove(path + ".1")
+	appendTestLines(t, path, "old-1", "old-2", "old-3")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	channel := make(chan string)
+	errc := make(chan error)
+	go func() {
+		errc <- Tail(ctx, path, TailParams{Lines: 2, PollInterval: 10 * time.Millisecond}, channel)
+	}()
+	assert.Equal([]string{"old-2", "old-3"}, receiveLines(t, channel, 2))
+
+	appendTestLines(t, path, "new-1", "new-2")
+	assert.Equal([]string{"new-1", "new-2"}, receiveLines(t, channel, 2))
+
+	// Partial lines wait for their newline
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0664)
+	f.WriteString("part")
+	time.Sleep(50 * time.Millisecond)
+	f.WriteString("ial\n")
+	f.Close()
+	assert.Equal([]string{"partial"}, receiveLines(t, channel, 1))
+
+	// Rename and recreate. Lines written to the old file after the rename
+	// must not be lost
+	old, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0664)
+	require.Nil(t, os.Rename(path, path+".1"))
+	old.WriteString("late-1\n")
+	old.Close()
+	appendTestLines(t, path, "rotated-1", "rotated-2")
+	assert.Equal([]string{"late-1", "rotated-1", "rotated-2"}, receiveLines(t, channel, 3))
+
+	// Truncate
+	require.Nil(t, os.Truncate(path, 0))
+	time.Sleep(50 * time.Millisecond)
+	appendTestLines(t, path, "truncated-1")
+	assert.Equal([]string{"truncated-1"}, receiveLines(t, channel, 1))
+
+	cancel()
+	select {
+	case err := <-errc:
+		assert.Equal(context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Tail did not return after cancel")
+	}
+}
+
+func TestTailFromStart(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	path := "/tmp/tail-from-start-test.txt"
+	os.Remove(path)
+	defer os.Remove(path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	channel := make(chan string)
+	go Tail(ctx, path, TailParams{FromStart: true, PollInterval: 10 * time.Millisecond}, channel)
+
+	// The file does not exist yet
+	time.Sleep(30 * time.Millisecond)
+	appendTestLines(t, path, "a", "b")
+	assert.Equal([]string{"a", "b"}, receiveLines(t, channel, 2))
+}
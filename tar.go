@@ -0,0 +1,121 @@
+package gocommons
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// TarReader iterates over the regular files in a tar archive.
+// Only entries whose base name matches one of the patterns are returned,
+// just like ListFiles. Use []string{"*"} to get every entry.
+type TarReader struct {
+	file     *File
+	reader   *tar.Reader
+	patterns []string
+}
+
+// TarEntry is a regular file in a tar archive.
+// Entries are streamed from the archive, so an entry can only be read
+// until the next call to TarReader.Next.
+type TarEntry struct {
+	*tar.Header
+	codecReader
+}
+
+// OpenTar opens the tar archive at path.
+// The archive may be compressed with any registered codec (.tar.gz, .tar.zst, ...).
+func OpenTar(path string, patterns []string) (*TarReader, error) {
+	return OpenTarFS(OS, path, patterns)
+}
+
+// OpenTarFS is like OpenTar but opens path in fsys
+func OpenTarFS(fsys FS, path string, patterns []string) (*TarReader, error) {
+	file, err := OpenFS(fsys, path, os.O_RDONLY, GZ_UNKNOWN)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := file.RawReader()
+	if err != nil {
+		file.Close()
+		return nil, errors.New(fmt.Sprintf("Failed to read '%v': %v", path, err))
+	}
+	return &TarReader{file, tar.NewReader(raw), patterns}, nil
+}
+
+// NewTarReader reads a tar archive from r.
+// Like OpenTar, r may be compressed with any registered codec.
+func NewTarReader(r io.Reader, patterns []string) (*TarReader, error) {
+	r, gz, err := sniffReader(r, "")
+	if err != nil {
+		return nil, err
+	}
+	codec, ok := GetCodec(gz)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("No codec registered for FileType %d", gz))
+	}
+	raw, err := codec.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &TarReader{nil, tar.NewReader(raw), patterns}, nil
+}
+
+// Next advances to the next matching entry.
+// It returns io.EOF when there are no more entries.
+func (tr *TarReader) Next() (*TarEntry, error) {
+	for {
+		header, err := tr.reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !header.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		matched, err := matchName(tr.patterns, path.Base(header.Name))
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+		// Members are often compressed on their own. Look inside
+		reader, err := newCodecReader(tr.reader, header.Name)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to read '%v': %v", header.Name, err))
+		}
+		return &TarEntry{header, reader}, nil
+	}
+}
+
+// Close closes the archive if it was opened by OpenTar
+func (tr *TarReader) Close() {
+	if tr.file != nil {
+		tr.file.Close()
+	}
+}
+
+// ListTar returns the names of the entries in the archive at path that
+// match patterns. Unlike ListFiles, names are in archive order.
+func ListTar(path string, patterns []string) (names []string, err error) {
+	tr, err := OpenTar(path, patterns)
+	if err != nil {
+		return nil, err
+	}
+	defer tr.Close()
+
+	for {
+		var entry *TarEntry
+		if entry, err = tr.Next(); err != nil {
+			break
+		}
+		names = append(names, entry.Name)
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,160 @@
+package gocommons
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tarTestEntry struct {
+	name  string
+	gz    FileType
+	lines string
+}
+
+var tarTestEntries = []tarTestEntry{
+	{"logs/a.txt", GZ_FALSE, "a1\na2\n"},
+	{"logs/b.log.gz", GZ_TRUE, "b1\nb2\nb3\n"},
+	{"logs/nested/c.log.zst", ZSTD, "c1\n"},
+	{"logs/d.log", GZ_FALSE, ""},
+	{"README", GZ_FALSE, "readme\n"},
+}
+
+func compressTestData(t *testing.T, data string, gz FileType) []byte {
+	require := require.New(t)
+
+	codec, ok := GetCodec(gz)
+	require.True(ok)
+	buf := new(bytes.Buffer)
+	stream, err := codec.NewWriter(buf)
+	require.Nil(err)
+	writer := newWriter(stream, gz, 0)
+	writer.Write([]byte(data))
+	require.Nil(writer.Close())
+	return buf.Bytes()
+}
+
+func writeTarTestFile(t *testing.T, path string, gz FileType) {
+	require := require.New(t)
+
+	f, err := Open(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, gz)
+	require.Nil(err)
+	defer f.Close()
+	writer, err := f.Writer(0)
+	require.Nil(err)
+
+	tw := tar.NewWriter(writer)
+	require.Nil(tw.WriteHeader(&tar.Header{Name: "logs/", Typeflag: tar.TypeDir, Mode: 0755}))
+	require.Nil(tw.WriteHeader(&tar.Header{Name: "logs/link.txt", Typeflag: tar.TypeSymlink, Linkname: "a.txt"}))
+	for _, entry := range tarTestEntries {
+		data := []byte(entry.lines)
+		if entry.gz != GZ_FALSE {
+			data = compressTestData(t, entry.lines, entry.gz)
+		}
+		require.Nil(tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(data))}))
+		_, err = tw.Write(data)
+		require.Nil(err)
+	}
+	require.Nil(tw.Close())
+	require.Nil(writer.Close())
+}
+
+func TestTarReader(t *testing.T) {
+	t.Parallel()
+
+	for _, gz := range []FileType{GZ_FALSE, GZ_TRUE, ZSTD} {
+		path := "/tmp/tar-test.tar" + gz.Suffix()
+		writeTarTestFile(t, path, gz)
+		defer os.Remove(path)
+		testTarReader(t, path)
+	}
+}
+
+func testTarReader(t *testing.T, path string) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tr, err := OpenTar(path, []string{"*.txt", "*.log*"})
+	require.Nil(err)
+	defer tr.Close()
+
+	idx := 0
+	for {
+		entry, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(err)
+		expected := tarTestEntries[idx]
+		assert.Equal(expected.name, entry.Name)
+		assert.Equal(expected.gz, entry.FileType(), "Wrong FileType for %v in %v", entry.Name, path)
+
+		channel := make(chan string)
+		go entry.AsyncRead(bufio.ScanLines, channel)
+		got := ""
+		for line := range channel {
+			got += line + "\n"
+		}
+		assert.Equal(expected.lines, got, "Mismatch in %v in %v", entry.Name, path)
+		idx++
+	}
+	assert.Equal(4, idx, "Wrong number of entries in %v", path)
+}
+
+func TestListTar(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := "/tmp/list-tar-test.tar.gz"
+	writeTarTestFile(t, path, GZ_TRUE)
+	defer os.Remove(path)
+
+	names, err := ListTar(path, []string{"*.gz", "README"})
+	require.Nil(err)
+	assert.Equal([]string{"logs/b.log.gz", "README"}, names)
+
+	names, err = ListTar(path, []string{"*"})
+	require.Nil(err)
+	assert.Equal(len(tarTestEntries), len(names), "Directories and links should be skipped")
+
+	_, err = ListTar(path, []string{"["})
+	assert.NotNil(err, "Should have failed on bad pattern")
+
+	_, err = ListTar("/tmp/does-not-exist.tar", []string{"*"})
+	assert.NotNil(err)
+}
+
+func TestNewTarReader(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := "/tmp/new-tar-reader-test.tar.gz"
+	writeTarTestFile(t, path, GZ_TRUE)
+	defer os.Remove(path)
+
+	data, err := os.ReadFile(path)
+	require.Nil(err)
+	tr, err := NewTarReader(bytes.NewReader(data), []string{"b.*"})
+	require.Nil(err)
+	entry, err := tr.Next()
+	require.Nil(err)
+	scanner, err := entry.Reader(0)
+	require.Nil(err)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	assert.Equal([]string{"b1", "b2", "b3"}, lines)
+	_, err = tr.Next()
+	assert.Equal(io.EOF, err)
+}
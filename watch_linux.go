@@ -0,0 +1,466 @@
+package gocommons
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const DEFAULT_WATCH_DEBOUNCE = 500 * time.Millisecond
+
+// WatchOp is what happened to a watched file
+type WatchOp int
+
+const (
+	WATCH_CREATE WatchOp = 1 << iota
+	WATCH_MODIFY
+	WATCH_MOVE
+	WATCH_DELETE
+)
+
+func (op WatchOp) String() string {
+	switch op {
+	case WATCH_CREATE:
+		return "create"
+	case WATCH_MODIFY:
+		return "modify"
+	case WATCH_MOVE:
+		return "move"
+	case WATCH_DELETE:
+		return "delete"
+	}
+	return "unknown"
+}
+
+var ErrWatchOverflow = errors.New("Too many changes to watch, events were lost")
+
+// WatchEvent is sent by Watch
+type WatchEvent struct {
+	Op   WatchOp
+	Path string
+	// Where a WATCH_MOVE came from
+	OldPath string
+}
+
+// WatchParams configure Watch
+type WatchParams struct {
+	// How long a file has to be left alone before it is reported as
+	// created, modified or moved. 0 uses DEFAULT_WATCH_DEBOUNCE
+	Debounce time.Duration
+	// Optional. Called once every directory under root is being watched.
+	// Changes made after it is called are not missed.
+	Ready func()
+}
+
+const watchMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR
+
+// watchPending is a change waiting for its file to settle
+type watchPending struct {
+	op      WatchOp
+	oldPath string
+	last    time.Time
+}
+
+// watchMove is the first half of a rename
+type watchMove struct {
+	path  string
+	isDir bool
+	at    time.Time
+}
+
+type watcher struct {
+	ctx      context.Context
+	root     string
+	patterns []string
+	params   WatchParams
+	channel  chan<- WatchEvent
+	fd       int
+
+	dirs    map[int32]string // Watched directories by watch descriptor
+	wds     map[string]int32
+	pending map[string]*watchPending
+	moves   map[uint32]watchMove // By cookie
+}
+
+// Watch sends changes to the files under root whose names match any of
+// patterns, like ListFiles, until ctx is done. Directories created under
+// root are watched as well.
+// Creations, modifications and moves are only reported once the file
+// has not changed for params.Debounce, so files that are still being
+// written are reported once. A file that is created and deleted in that
+// time is never reported. Files that were there when Watch started are
+// not reported unless they change. When a directory is moved out of
+// root, the files in it are not reported.
+// The channel is closed when Watch returns. Watch returns ctx.Err() once
+// ctx is done, ErrWatchOverflow if the kernel dropped events or an error
+// if root itself goes away.
+func Watch(ctx context.Context, root string, patterns []string, params WatchParams, channel chan<- WatchEvent) error {
+	defer close(channel)
+	if params.Debounce <= 0 {
+		params.Debounce = DEFAULT_WATCH_DEBOUNCE
+	}
+	if _, err := matchName(patterns, ""); err != nil {
+		return err
+	}
+	if isDir, err := IsDir(root); err != nil {
+		return err
+	} else if !isDir {
+		return &fs.PathError{Op: "watch", Path: root, Err: syscall.ENOTDIR}
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+	// Non-blocking, so reads can be interrupted by closing it
+	file := os.NewFile(uintptr(fd), "inotify")
+	defer file.Close()
+
+	w := &watcher{
+		ctx:      ctx,
+		root:     filepath.Clean(root),
+		patterns: patterns,
+		params:   params,
+		channel:  channel,
+		fd:       fd,
+		dirs:     make(map[int32]string),
+		wds:      make(map[string]int32),
+		pending:  make(map[string]*watchPending),
+		moves:    make(map[uint32]watchMove),
+	}
+	if err = w.addTree(w.root, false, time.Now()); err != nil {
+		return err
+	}
+	if params.Ready != nil {
+		params.Ready()
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	events := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			buf := make([]byte, 64*1024)
+			n, err := file.Read(buf)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case events <- buf[:n]:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	tick := params.Debounce / 4
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err = <-readErr:
+			return err
+		case buf := <-events:
+			err = w.handle(buf, time.Now())
+		case now := <-ticker.C:
+			err = w.flush(now)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (w *watcher) matches(path string) bool {
+	matched, _ := matchName(w.patterns, filepath.Base(path))
+	return matched
+}
+
+func (w *watcher) emit(ev WatchEvent) error {
+	select {
+	case w.channel <- ev:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+// addTree watches dir and every directory below it. With report, the
+// files found are reported as created.
+func (w *watcher) addTree(dir string, report bool, now time.Time) error {
+	mask := uint32(watchMask)
+	if dir != w.root {
+		mask |= syscall.IN_DONT_FOLLOW
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, mask)
+	if err == syscall.ENOENT || err == syscall.ENOTDIR {
+		// Gone already
+		return nil
+	} else if err != nil {
+		return &fs.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+	if old, ok := w.dirs[int32(wd)]; ok && w.wds[old] == int32(wd) {
+		delete(w.wds, old)
+	}
+	w.dirs[int32(wd)] = dir
+	w.wds[dir] = int32(wd)
+
+	// Anything created before the watch was added has to be picked up here
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if err = w.addTree(path, report, now); err != nil {
+				return err
+			}
+		} else if report {
+			w.touch(path, WATCH_CREATE, now)
+		}
+	}
+	return nil
+}
+
+// forgetDir stops watching dir and everything below it
+func (w *watcher) forgetDir(dir string) {
+	for path, wd := range w.wds {
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.wds, path)
+			delete(w.dirs, wd)
+		}
+	}
+	for path := range w.pending {
+		if strings.HasPrefix(path, dir+"/") {
+			delete(w.pending, path)
+		}
+	}
+}
+
+// handle parses a buffer of inotify events
+func (w *watcher) handle(buf []byte, now time.Time) error {
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		start := offset + syscall.SizeofInotifyEvent
+		offset = start + int(raw.Len)
+		if offset > len(buf) {
+			break
+		}
+		name := strings.TrimRight(string(buf[start:offset]), "\x00")
+		if err := w.event(raw.Wd, raw.Mask, raw.Cookie, name, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *watcher) event(wd int32, mask uint32, cookie uint32, name string, now time.Time) error {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		return ErrWatchOverflow
+	}
+	dir, ok := w.dirs[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		// The directory is gone or no longer watched
+		if ok && w.wds[dir] == wd {
+			delete(w.wds, dir)
+		}
+		delete(w.dirs, wd)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	if mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0 {
+		if dir == w.root {
+			return &fs.PathError{Op: "watch", Path: w.root, Err: fs.ErrNotExist}
+		}
+		// Everything else is handled through the events of the parent
+		return nil
+	}
+
+	path := filepath.Join(dir, name)
+	isDir := mask&syscall.IN_ISDIR != 0
+	switch {
+	case mask&syscall.IN_CREATE != 0:
+		if isDir {
+			return w.addTree(path, true, now)
+		}
+		w.touch(path, WATCH_CREATE, now)
+	case mask&(syscall.IN_MODIFY|syscall.IN_CLOSE_WRITE) != 0:
+		if !isDir {
+			w.touch(path, WATCH_MODIFY, now)
+		}
+	case mask&syscall.IN_MOVED_FROM != 0:
+		w.moves[cookie] = watchMove{path: path, isDir: isDir, at: now}
+	case mask&syscall.IN_MOVED_TO != 0:
+		from, ok := w.moves[cookie]
+		delete(w.moves, cookie)
+		if !ok {
+			// Moved in from outside of root
+			if isDir {
+				return w.addTree(path, true, now)
+			}
+			w.touch(path, WATCH_CREATE, now)
+		} else if isDir {
+			return w.moveDir(from.path, path, now)
+		} else {
+			return w.move(from.path, path, now)
+		}
+	case mask&syscall.IN_DELETE != 0:
+		// Directories are cleaned up by IN_IGNORED
+		if !isDir {
+			return w.remove(path)
+		}
+	}
+	return nil
+}
+
+// touch records a change to path. An earlier change that has not been
+// reported yet determines the op.
+func (w *watcher) touch(path string, op WatchOp, now time.Time) {
+	if !w.matches(path) {
+		return
+	}
+	if p, ok := w.pending[path]; ok {
+		p.last = now
+		return
+	}
+	w.pending[path] = &watchPending{op: op, last: now}
+}
+
+func (w *watcher) remove(path string) error {
+	p, ok := w.pending[path]
+	delete(w.pending, path)
+	switch {
+	case ok && p.op == WATCH_CREATE:
+		// Never reported, so there is nothing to delete
+		return nil
+	case ok && p.op == WATCH_MOVE:
+		path = p.oldPath
+	case !w.matches(path):
+		return nil
+	}
+	return w.emit(WatchEvent{Op: WATCH_DELETE, Path: path})
+}
+
+func (w *watcher) move(from string, to string, now time.Time) error {
+	origin := from
+	p, ok := w.pending[from]
+	delete(w.pending, from)
+	if ok && p.op == WATCH_CREATE {
+		w.touch(to, WATCH_CREATE, now)
+		return nil
+	} else if ok && p.op == WATCH_MOVE {
+		origin = p.oldPath
+	}
+
+	switch originMatch, toMatch := w.matches(origin), w.matches(to); {
+	case originMatch && toMatch:
+		if origin == to {
+			w.pending[to] = &watchPending{op: WATCH_MODIFY, last: now}
+		} else {
+			w.pending[to] = &watchPending{op: WATCH_MOVE, oldPath: origin, last: now}
+		}
+	case originMatch:
+		return w.emit(WatchEvent{Op: WATCH_DELETE, Path: origin})
+	case toMatch:
+		w.touch(to, WATCH_CREATE, now)
+	}
+	return nil
+}
+
+// moveDir follows a directory that was moved within root and reports
+// every file in it as moved
+func (w *watcher) moveDir(from string, to string, now time.Time) error {
+	for path, wd := range w.wds {
+		if path == from || strings.HasPrefix(path, from+"/") {
+			moved := to + path[len(from):]
+			delete(w.wds, path)
+			w.wds[moved] = wd
+			w.dirs[wd] = moved
+		}
+	}
+	var files []string
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if entry.IsDir() {
+				if err = walk(path); err != nil {
+					return err
+				}
+			} else {
+				files = append(files, path)
+			}
+		}
+		return nil
+	}
+	if err := walk(to); err != nil {
+		return err
+	}
+	for _, path := range files {
+		if err := w.move(from+path[len(to):], path, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush reports the changes that have settled
+func (w *watcher) flush(now time.Time) error {
+	for cookie, m := range w.moves {
+		if now.Sub(m.at) < w.params.Debounce {
+			continue
+		}
+		// Never moved back in, so it left root
+		delete(w.moves, cookie)
+		if m.isDir {
+			w.forgetDir(m.path)
+		} else if err := w.remove(m.path); err != nil {
+			return err
+		}
+	}
+
+	var due []string
+	for path, p := range w.pending {
+		if now.Sub(p.last) >= w.params.Debounce {
+			due = append(due, path)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return w.pending[due[i]].last.Before(w.pending[due[j]].last)
+	})
+	for _, path := range due {
+		p := w.pending[path]
+		delete(w.pending, path)
+		if err := w.emit(WatchEvent{Op: p.op, Path: path, OldPath: p.oldPath}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
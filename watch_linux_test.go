@@ -0,0 +1,125 @@
+package gocommons
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func expectWatchEvent(t *testing.T, channel chan WatchEvent) WatchEvent {
+	select {
+	case ev, ok := <-channel:
+		require.True(t, ok, "Channel closed early")
+		return ev
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "Timed out waiting for event")
+	}
+	return WatchEvent{}
+}
+
+// startWatch runs Watch in the background and returns once it is ready
+func startWatch(t *testing.T, ctx context.Context, dir string, patterns []string, params WatchParams, channel chan WatchEvent) chan error {
+	ready := make(chan struct{})
+	params.Ready = func() { close(ready) }
+	result := make(chan error, 1)
+	go func() {
+		result <- Watch(ctx, dir, patterns, params, channel)
+	}()
+	select {
+	case <-ready:
+	case err := <-result:
+		require.FailNow(t, "Watch failed to start", "%v", err)
+	}
+	return result
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := os.MkdirTemp("", "watch")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	require.Nil(os.WriteFile(filepath.Join(dir, "old.txt"), []byte("old\n"), 0664))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	channel := make(chan WatchEvent, 100)
+	result := startWatch(t, ctx, dir, []string{"*.txt"}, WatchParams{Debounce: 100 * time.Millisecond}, channel)
+
+	// Written in pieces, reported once. Files that don't match are skipped
+	require.Nil(os.WriteFile(filepath.Join(dir, "skip.log"), []byte("x\n"), 0664))
+	f, err := os.Create(filepath.Join(dir, "a.txt"))
+	require.Nil(err)
+	for i := 0; i < 5; i++ {
+		f.WriteString("line\n")
+		time.Sleep(20 * time.Millisecond)
+	}
+	f.Close()
+	assert.Equal(WatchEvent{Op: WATCH_CREATE, Path: filepath.Join(dir, "a.txt")}, expectWatchEvent(t, channel))
+
+	// New directories are watched, including what is already in them
+	sub := filepath.Join(dir, "sub", "deeper")
+	require.Nil(os.MkdirAll(sub, 0775))
+	require.Nil(os.WriteFile(filepath.Join(sub, "b.txt"), []byte("b\n"), 0664))
+	assert.Equal(WatchEvent{Op: WATCH_CREATE, Path: filepath.Join(sub, "b.txt")}, expectWatchEvent(t, channel))
+
+	require.Nil(os.WriteFile(filepath.Join(dir, "old.txt"), []byte("new\n"), 0664))
+	assert.Equal(WatchEvent{Op: WATCH_MODIFY, Path: filepath.Join(dir, "old.txt")}, expectWatchEvent(t, channel))
+
+	require.Nil(os.Rename(filepath.Join(dir, "a.txt"), filepath.Join(sub, "c.txt")))
+	assert.Equal(WatchEvent{Op: WATCH_MOVE, Path: filepath.Join(sub, "c.txt"), OldPath: filepath.Join(dir, "a.txt")}, expectWatchEvent(t, channel))
+
+	// Moved directories keep being watched under their new name
+	moved := filepath.Join(dir, "moved")
+	require.Nil(os.Rename(filepath.Join(dir, "sub"), moved))
+	first := expectWatchEvent(t, channel)
+	second := expectWatchEvent(t, channel)
+	assert.ElementsMatch([]WatchEvent{
+		{Op: WATCH_MOVE, Path: filepath.Join(moved, "deeper", "b.txt"), OldPath: filepath.Join(sub, "b.txt")},
+		{Op: WATCH_MOVE, Path: filepath.Join(moved, "deeper", "c.txt"), OldPath: filepath.Join(sub, "c.txt")},
+	}, []WatchEvent{first, second})
+
+	require.Nil(os.Remove(filepath.Join(moved, "deeper", "b.txt")))
+	assert.Equal(WatchEvent{Op: WATCH_DELETE, Path: filepath.Join(moved, "deeper", "b.txt")}, expectWatchEvent(t, channel))
+
+	// Created and deleted before it settled
+	require.Nil(os.WriteFile(filepath.Join(dir, "brief.txt"), []byte("x\n"), 0664))
+	require.Nil(os.Remove(filepath.Join(dir, "brief.txt")))
+	require.Nil(os.WriteFile(filepath.Join(dir, "last.txt"), []byte("x\n"), 0664))
+	assert.Equal(WatchEvent{Op: WATCH_CREATE, Path: filepath.Join(dir, "last.txt")}, expectWatchEvent(t, channel))
+
+	cancel()
+	assert.Equal(context.Canceled, <-result)
+	_, ok := <-channel
+	assert.False(ok, "Channel should have been closed")
+}
+
+func TestWatchRoot(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	err := Watch(context.Background(), "/missing/dir", []string{"*"}, WatchParams{}, make(chan WatchEvent))
+	assert.NotNil(err)
+
+	dir, err := os.MkdirTemp("", "watch")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	result := startWatch(t, context.Background(), dir, []string{"*"}, WatchParams{}, make(chan WatchEvent))
+	require.Nil(os.Remove(dir))
+	select {
+	case err = <-result:
+		assert.NotNil(err, "Should have failed when root went away")
+	case <-time.After(5 * time.Second):
+		require.FailNow("Watch did not return")
+	}
+}
@@ -0,0 +1,176 @@
+package gocommons
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ZipFile is a zip archive opened for reading
+type ZipFile struct {
+	Path   string
+	file   *File
+	reader *zip.Reader
+}
+
+// ZipEntry is an open file inside a zip archive.
+// Like TarEntry, its contents may themselves be compressed.
+type ZipEntry struct {
+	*zip.File
+	codecReader
+	rc io.ReadCloser
+}
+
+func OpenZip(path string) (*ZipFile, error) {
+	return OpenZipFS(OS, path)
+}
+
+// OpenZipFS is like OpenZip but opens path in fsys
+func OpenZipFS(fsys FS, path string) (*ZipFile, error) {
+	file, err := OpenFS(fsys, path, os.O_RDONLY, GZ_FALSE)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.handle.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	reader, err := zip.NewReader(file.handle, stat.Size())
+	if err != nil {
+		file.Close()
+		return nil, errors.New(fmt.Sprintf("Failed to read '%v': %v", path, err))
+	}
+	return &ZipFile{path, file, reader}, nil
+}
+
+func (z *ZipFile) Close() {
+	z.file.Close()
+}
+
+// List returns the sorted names of the regular files in the archive whose
+// base name matches one of patterns, just like ListFiles
+func (z *ZipFile) List(patterns []string) (names []string, err error) {
+	for _, f := range z.reader.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		var matched bool
+		if matched, err = matchName(patterns, path.Base(f.Name)); err != nil {
+			return nil, err
+		}
+		if matched {
+			names = append(names, f.Name)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// Open opens the entry called name
+func (z *ZipFile) Open(name string) (*ZipEntry, error) {
+	for _, f := range z.reader.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to open '%v' in '%v': %v", name, z.Path, err))
+		}
+		reader, err := newCodecReader(rc, name)
+		if err != nil {
+			rc.Close()
+			return nil, errors.New(fmt.Sprintf("Failed to read '%v' in '%v': %v", name, z.Path, err))
+		}
+		return &ZipEntry{f, reader, rc}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("No entry '%v' in '%v'", name, z.Path))
+}
+
+func ListZip(path string, patterns []string) ([]string, error) {
+	z, err := OpenZip(path)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	return z.List(patterns)
+}
+
+func (e *ZipEntry) Close() {
+	e.rc.Close()
+}
+
+// WriteZip creates a zip archive at path containing files.
+// Entries are named relative to root, so that the output of
+// ListFiles(root, ...) ends up at the top of the archive. Files are
+// added as they are, compressed or not. Compressed files are stored
+// rather than deflated. The archive is written
+// atomically and path is left untouched on failure.
+func WriteZip(path string, root string, files []string) (err error) {
+	var out *AtomicFile
+	var writer Writer
+
+	if out, err = OpenAtomic(path, GZ_FALSE); err != nil {
+		return
+	}
+	defer out.Close()
+	if writer, err = out.Writer(0); err != nil {
+		return
+	}
+
+	zw := zip.NewWriter(writer)
+	for _, file := range files {
+		if err = addZipEntry(zw, root, file); err != nil {
+			return
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return
+	}
+	return out.Commit()
+}
+
+func addZipEntry(zw *zip.Writer, root string, file string) (err error) {
+	var in *File
+	var header *zip.FileHeader
+	var entry io.Writer
+
+	name, err := filepath.Rel(root, file)
+	if err != nil || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return errors.New(fmt.Sprintf("'%v' is not under '%v'", file, root))
+	}
+	if in, err = Open(file, os.O_RDONLY, GZ_FALSE); err != nil {
+		return
+	}
+	defer in.Close()
+	stat, err := in.handle.Stat()
+	if err != nil {
+		return
+	}
+	if header, err = zip.FileInfoHeader(stat); err != nil {
+		return
+	}
+	reader, gz, err := sniffReader(in.handle, file)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to read '%v': %v", file, err))
+	}
+	header.Name = filepath.ToSlash(name)
+	header.Method = zip.Deflate
+	if gz != GZ_FALSE {
+		// Deflating compressed data is a waste of time
+		header.Method = zip.Store
+	}
+	if entry, err = zw.CreateHeader(header); err != nil {
+		return
+	}
+	if _, err = io.Copy(entry, reader); err != nil {
+		return errors.New(fmt.Sprintf("Failed to add '%v': %v", file, err))
+	}
+	return
+}
@@ -0,0 +1,88 @@
+package gocommons
+
+import (
+	"archive/zip"
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZip(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := "/tmp/zip-test"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	require.Nil(Makedirs(filepath.Join(dir, "in", "nested")))
+	expected := map[string]string{
+		"a.txt":         "a1\na2\n",
+		"..data.txt":    "d1\n",
+		"nested/b.txt":  "b1\n",
+		"nested/c.gz":   "c1\nc2\nc3\n",
+		"nested/skip.x": "skip\n",
+	}
+	for name, contents := range expected {
+		f, err := Open(filepath.Join(dir, "in", name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, GZ_UNKNOWN)
+		require.Nil(err)
+		writer, err := f.Writer(0)
+		require.Nil(err)
+		writer.Write([]byte(contents))
+		require.Nil(writer.Close())
+		f.Close()
+	}
+
+	files, err := ListFiles(filepath.Join(dir, "in"), []string{"*.txt", "*.gz"})
+	require.Nil(err)
+	path := filepath.Join(dir, "out.zip")
+	require.Nil(WriteZip(path, filepath.Join(dir, "in"), files))
+
+	names, err := ListZip(path, []string{"*"})
+	require.Nil(err)
+	assert.Equal([]string{"..data.txt", "a.txt", "nested/b.txt", "nested/c.gz"}, names)
+
+	z, err := OpenZip(path)
+	require.Nil(err)
+	defer z.Close()
+
+	names, err = z.List([]string{"*.gz"})
+	require.Nil(err)
+	assert.Equal([]string{"nested/c.gz"}, names)
+
+	methods := map[string]uint16{"..data.txt": zip.Deflate, "a.txt": zip.Deflate, "nested/c.gz": zip.Store}
+	for name, method := range methods {
+		entry, err := z.Open(name)
+		require.Nil(err)
+		// Compressed files are stored as they are
+		assert.Equal(method, entry.Method, "Wrong method for %v", name)
+		channel := make(chan string)
+		go entry.AsyncRead(bufio.ScanLines, channel)
+		got := ""
+		for line := range channel {
+			got += line + "\n"
+		}
+		assert.Equal(expected[name], got, "Mismatch in %v", name)
+		entry.Close()
+	}
+
+	_, err = z.Open("missing.txt")
+	assert.NotNil(err)
+	_, err = z.List([]string{"["})
+	assert.NotNil(err)
+
+	// Files outside root are refused and nothing is written
+	bad := filepath.Join(dir, "bad.zip")
+	err = WriteZip(bad, filepath.Join(dir, "in", "nested"), files)
+	assert.NotNil(err)
+	exists, _ := Exists(bad)
+	assert.False(exists)
+
+	_, err = OpenZip(filepath.Join(dir, "in", "a.txt"))
+	assert.NotNil(err, "Should have failed to open non-zip")
+}